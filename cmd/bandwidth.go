@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	sastopo "github.com/bensallen/sastopo/lib"
+)
+
+// bandwidthCmd represents the bandwidth command
+var bandwidthCmd = &cobra.Command{
+	Use:   "bandwidth",
+	Short: "Show bandwidth oversubscription of the SAS topology",
+	Long: `Sum the nominal throughput of the drives behind every expander uplink, HBA
+port, HBA and PCIe link, and compare it with the link's capacity: lanes times
+negotiated SAS rate, or PCIe width times generation rate.
+
+A drive's throughput is split evenly between its paths.`,
+	Args:          cobra.NoArgs,
+	RunE:          bandwidth,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	RootCmd.AddCommand(bandwidthCmd)
+	bandwidthCmd.Flags().Float64Var(&conf.HDDThroughput, "hdd", 250, "Nominal throughput of a rotational drive in MB/s")
+	bandwidthCmd.Flags().Float64Var(&conf.SSDThroughput, "ssd", 1000, "Nominal throughput of a solid state drive in MB/s")
+}
+
+func bandwidth(cmd *cobra.Command, args []string) error {
+	loadConf()
+
+	devices, _, enclosures, HBAs, err := sastopo.ScsiDevices(conf)
+	if err != nil {
+		return err
+	}
+	links := sastopo.Bandwidth(devices, enclosures, HBAs, conf.HDDThroughput, conf.SSDThroughput)
+
+	var bottleneck *sastopo.Link
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "LEVEL\tNAME\tLANES\tPATHS\tDEMAND MB/s\tCAPACITY MB/s\tRATIO\n")
+	for _, l := range links {
+		ratio := "-"
+		if l.Capacity > 0 {
+			ratio = fmt.Sprintf("%.2f:1", l.Ratio())
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.0f\t%.0f\t%s\n", l.Level, l.Name, l.Lanes, l.Paths, l.Demand, l.Capacity, ratio)
+		if bottleneck == nil || l.Ratio() > bottleneck.Ratio() {
+			bottleneck = l
+		}
+	}
+	w.Flush()
+
+	if bottleneck != nil && bottleneck.Ratio() > 0 {
+		fmt.Printf("Bottleneck: %s %s at %.2f:1\n", bottleneck.Level, bottleneck.Name, bottleneck.Ratio())
+	}
+	return nil
+}
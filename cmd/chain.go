@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	sastopo "github.com/bensallen/sastopo/lib"
+)
+
+// chainCmd represents the chain command
+var chainCmd = &cobra.Command{
+	Use:   "chain",
+	Short: "Show daisy chained enclosures on each HBA port",
+	Long: `Show the enclosures cascaded from each HBA port, nearest the HBA first,
+ordered from the expanders in the sysfs paths of their SES devices.
+
+The chains are checked against the Cabling spec in the config file, which
+lists the enclosures expected on each chain keyed by <HBA>:<port>, ex: C5:0,
+and against the Chain position configured for each enclosure.`,
+	Args:          cobra.NoArgs,
+	RunE:          chain,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	RootCmd.AddCommand(chainCmd)
+}
+
+func chain(cmd *cobra.Command, args []string) error {
+	loadConf()
+
+	_, _, enclosures, _, err := sastopo.ScsiDevices(conf)
+	if err != nil {
+		return err
+	}
+
+	chains := sastopo.Chains(enclosures)
+	for _, c := range chains {
+		fmt.Println(c)
+	}
+	problems := sastopo.CheckCabling(chains, enclosures, conf.Cabling)
+	for _, p := range problems {
+		fmt.Printf("Cabling: %s\n", p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d cabling checks failed", len(problems))
+	}
+	return nil
+}
@@ -2,10 +2,16 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"strconv"
 	"strings"
+	"text/template"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
 
 	"sort"
 
@@ -13,40 +19,106 @@ import (
 	yaml "gopkg.in/yaml.v2"
 )
 
-var conf sastopo.Conf
+var (
+	conf                 sastopo.Conf
+	discoverOutput       string
+	discoverTemplate     string
+	discoverTemplateFile string
+)
 
 // discoverCmd represents the discover command
 var discoverCmd = &cobra.Command{
 	Use:   "discover",
 	Short: "Discover host's SAS Topology",
-	Long:  "Discover host's SAS Topology",
-	Run:   run,
+	Long: `Discover host's SAS Topology.
+
+--template and --template-file format the topology with a Go text/template
+instead, executed over the exported sastopo View: .Host, .HBAs, .Enclosures
+with their .Slots, .Devices with their .Paths, and .Diagnostics. Helpers are
+sortBy, join, csv, json, pad, upper and lower, ex:
+
+  sastopo discover --template '{{range sortBy "Serial" .Devices}}{{csv .Serial .Enclosure .Slot}}
+{{end}}'`,
+	Run: run,
 }
 
 func init() {
 	RootCmd.AddCommand(discoverCmd)
 	discoverCmd.Flags().BoolVarP(&conf.Summary, "summary", "s", true, "Show summary of SAS devices")
-	discoverCmd.Flags().BoolVarP(&conf.Mismatch, "mismatch", "m", false, "Show devices with path count mismatch")
+	discoverCmd.Flags().BoolVarP(&conf.Mismatch, "mismatch", "m", false, "Show devices with path count, device-mapper multipath or IOM mismatch")
+	discoverCmd.Flags().BoolVarP(&conf.Reconcile, "reconcile", "r", false, "Show disagreements between SES bay status and SAS devices")
 	discoverCmd.Flags().IntVarP(&conf.PathCount, "pathcount", "p", 2, "Number of expected paths to each SAS device")
 	discoverCmd.Flags().IntVar(&conf.SysfsMatchPathEncl, "sysfsMatchPathEncl", 8, "Number of sysfs elements expected for a sysfs device")
+	discoverCmd.Flags().StringVarP(&discoverOutput, "output", "o", "text", "Output format: text, or dot for a Graphviz graph of the SAS domain")
+	discoverCmd.Flags().StringVar(&discoverTemplate, "template", "", "Format the topology with a Go text/template")
+	discoverCmd.Flags().StringVar(&discoverTemplateFile, "template-file", "", "Format the topology with a Go text/template read from a file")
 
 }
 
 func run(cmd *cobra.Command, args []string) {
+	if discoverOutput != "text" && discoverOutput != "dot" {
+		log.Fatalf("error: unknown output format %s, expected text or dot", discoverOutput)
+	}
+	tmpl := discoverViewTemplate()
 	loadConf()
 
 	devices, multiPathDevices, enclosures, HBAs, err := sastopo.ScsiDevices(conf)
 	if err != nil {
 		fmt.Print(err)
 	}
+	if discoverOutput == "dot" {
+		fmt.Print(sastopo.DOT(devices, enclosures, HBAs))
+		return
+	}
+	if tmpl != nil {
+		if err := tmpl.Execute(os.Stdout, sastopo.NewView(multiPathDevices, enclosures, HBAs, conf.PathCount)); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		return
+	}
 	if conf.Mismatch {
 		findDevMissingPaths(conf.PathCount, devices)
+		for _, mismatch := range sastopo.DMMismatches(multiPathDevices) {
+			fmt.Printf("Multipath Mismatch: %s\n", mismatch)
+		}
+		for _, mismatch := range sastopo.IOMMismatches(multiPathDevices) {
+			fmt.Printf("IOM Mismatch: %s\n", mismatch)
+		}
+	}
+	if conf.Reconcile {
+		for _, finding := range sastopo.Reconcile(devices, enclosures) {
+			fmt.Println(finding)
+		}
 	}
 	if conf.Summary {
 		summary(devices, multiPathDevices, enclosures, HBAs)
 	}
 }
 
+// discoverViewTemplate parses the --template or --template-file template, or
+// returns nil if neither was given
+func discoverViewTemplate() *template.Template {
+	if discoverTemplate != "" && discoverTemplateFile != "" {
+		log.Fatalf("error: --template and --template-file can't be used together")
+	}
+	text := discoverTemplate
+	if discoverTemplateFile != "" {
+		data, err := ioutil.ReadFile(discoverTemplateFile)
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		text = string(data)
+	}
+	if text == "" {
+		return nil
+	}
+	tmpl, err := sastopo.ParseViewTemplate("discover", text)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	return tmpl
+}
+
 func findDevMissingPaths(count int, devices map[string]*sastopo.Device) {
 	for _, d := range devices {
 		if len(d.MultiPath.Paths) != count {
@@ -63,18 +135,32 @@ func summary(devices map[string]*sastopo.Device, multiPathDevices map[string]*sa
 	//}
 	fmt.Printf("Found %d Unique Multi-pathed SAS Devices\n", len(multiPathDevices))
 	for hba := range HBAs {
-		fmt.Printf("Found HBA: %s, Slot: %s, Host: %s\n", HBAs[hba].PciID, HBAs[hba].Slot, HBAs[hba].Host)
+		fmt.Printf("Found HBA: %s, Slot: %s (%s), Host: %s\n", HBAs[hba].PciID, HBAs[hba].Slot, slotSource(HBAs[hba]), HBAs[hba].Host)
 	}
 
 	fmt.Printf("Found %d Enclosures\n", len(enclosures))
+	for _, c := range sastopo.Chains(enclosures) {
+		fmt.Printf("Chain: %s\n", c)
+	}
 	for enclosure := range enclosures {
 		fmt.Printf("Enclosure: \n    Vendor: %s, Model: %s, Serial: %s\n", enclosure.Vendor(), enclosure.Model(), enclosure.Serial())
+		if enclosure.Label != "" || enclosure.Rack != "" || enclosure.LogicalID() != "" {
+			fmt.Printf("    Label: %s (%s), Logical ID: %s, Rack: %s, U: %d, Chain: %d\n",
+				enclosure.Label, labelSource(enclosure), enclosure.LogicalID(), enclosure.Rack, enclosure.U, enclosure.Chain)
+		}
 
 		fmt.Printf("    Paths:\n")
 		for path := range enclosure.MultiPathDevice.Paths {
 			fmt.Printf("        HBA: %s, Slot %s, Port: %s, Phy IDs: %s\n", path.HBA.PciID, path.HBA.Slot, path.Port, strings.Join(path.HBA.Port(path.Port).PhyIds(), ","))
 		}
-		fmt.Printf("    Slots %d of %d populated\n", len(enclosure.Slots), len(enclosure.Slots))
+		for _, drawer := range enclosure.Drawers {
+			fmt.Printf("    Drawer: %s, Slots: %d, Elements: %d\n", drawer.Name, len(drawer.Slots), len(drawer.Elements))
+		}
+		fmt.Printf("    Slots %d of %d populated", enclosure.PopulatedSlots(), enclosure.TotalSlots())
+		if empty := enclosure.EmptySlots(); len(empty) > 0 {
+			fmt.Printf(", empty: %s", joinInts(empty, ", "))
+		}
+		fmt.Printf("\n")
 
 		var slots []int
 		for slot := range enclosure.Slots {
@@ -83,19 +169,72 @@ func summary(devices map[string]*sastopo.Device, multiPathDevices map[string]*sa
 		sort.Ints(slots)
 
 		for _, slot := range slots {
-			fmt.Printf("    Slot: %d\n", slot)
 			mp := enclosure.Slots[slot]
+			fmt.Printf("    Slot: %d", slot)
+			if label := mp.SlotLabel(); label != "" {
+				fmt.Printf(", Label: %s", label)
+			}
+			if drawer := enclosure.Drawer(slot); drawer != nil {
+				fmt.Printf(", Drawer: %s", drawer.Name)
+			}
+			if el := enclosure.SlotElement(slot); el != nil {
+				fmt.Printf(", Status: %s", el.StatusString())
+			}
+			fmt.Printf("\n")
 			fmt.Printf("        Vendor: %s, Model: %s, Serial: %s\n", mp.Vendor(), mp.Model(), mp.Serial())
+			if mp.DM != nil {
+				fmt.Printf("        Multipath: %s (%s), UUID: %s\n", mp.DM.Map, mp.DM.Name, mp.DM.UUID)
+			}
 			mpDevices := mp.Devices()
 			fmt.Printf("        Paths:\n")
 			for i := 0; i < len(mpDevices); i++ {
-				fmt.Printf("            HBA: %s, SG: %s, Device: %s\n", mpDevices[i].HBA.Slot, mpDevices[i].SG, mpDevices[i].Block)
+				fmt.Printf("            HBA: %s, SG: %s, Device: %s, State: %s", mpDevices[i].HBA.Slot, mpDevices[i].SG, mpDevices[i].Block, mpDevices[i].State)
+				if mpDevices[i].IOM != "" {
+					fmt.Printf(", IOM: %s", mpDevices[i].IOM)
+				}
+				if mpDevices[i].DMState != "" {
+					fmt.Printf(", Multipath: %s (group %d)", mpDevices[i].DMState, mpDevices[i].DMGroup)
+				}
+				fmt.Printf("\n")
 			}
 		}
 
 	}
 }
 
+// slotSource describes where an HBA's slot label came from
+func slotSource(hba *sastopo.HBA) string {
+	switch hba.SlotSource {
+	case "config":
+		return "from config"
+	case "pci_slot":
+		return "from /sys/bus/pci/slots"
+	case "smbios":
+		return "from SMBIOS"
+	}
+	return "unknown"
+}
+
+// labelSource describes where an enclosure's label came from
+func labelSource(enclosure *sastopo.Enclosure) string {
+	switch enclosure.LabelSource {
+	case "config":
+		return "from config"
+	case "hba_port":
+		return "from HBA port"
+	}
+	return "none"
+}
+
+// joinInts formats a slice of ints as a sep separated string
+func joinInts(ints []int, sep string) string {
+	s := make([]string, len(ints))
+	for i, n := range ints {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, sep)
+}
+
 func loadConf() {
 
 	var data = []byte(`
@@ -117,4 +256,39 @@ EnclLabels:
 		log.Fatalf("error: %v", err)
 	}
 
+	loadConfFile()
+}
+
+// loadConfFile reads the config file found by initConfig, if any, into conf.
+// Flags given on the command line take precedence over the file.
+func loadConfFile() {
+	file := viper.ConfigFileUsed()
+	if file == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	// The file is read over the flag values cobra already parsed, so the
+	// flags given on the command line are set again afterwards. Slice flags
+	// would append to themselves and are never read from the file.
+	if cmd, _, err := RootCmd.Find(os.Args[1:]); err == nil {
+		changed := map[string]string{}
+		cmd.Flags().Visit(func(f *pflag.Flag) {
+			if !strings.HasSuffix(f.Value.Type(), "Slice") {
+				changed[f.Name] = f.Value.String()
+			}
+		})
+		defer func() {
+			for name, value := range changed {
+				cmd.Flags().Set(name, value)
+			}
+		}()
+	}
+
+	if err := yaml.Unmarshal(data, &conf); err != nil {
+		log.Fatalf("error: %v", err)
+	}
 }
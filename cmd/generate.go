@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	sastopo "github.com/bensallen/sastopo/lib"
+)
+
+var (
+	generateDryRun bool
+	generateYes    bool
+	vdevIDFile     string
+	vdevIDMode     string
+	vdevIDAliases  bool
+	multipathFile  string
+	multipathOpts  sastopo.MultipathOptions
+)
+
+// generateCmd represents the generate command
+var generateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate configuration files from the SAS topology",
+}
+
+// generateVdevIDCmd represents the generate vdev_id command
+var generateVdevIDCmd = &cobra.Command{
+	Use:   "vdev_id",
+	Short: "Generate a ZFS vdev_id.conf",
+	Long: `Generate a ZFS vdev_id.conf with a channel line for every HBA port an
+enclosure is connected to, so disks are named after their channel and slot.
+
+In multipath mode every path of an enclosure shares one channel name, in
+sas_direct mode each HBA port gets its own. Channel names come from EnclLabels
+in the config file, keyed by HBA PCI ID then port, or in multipath mode the
+enclosure label, otherwise enclosures are lettered in serial order.
+
+With --aliases, alias lines for each slot's /dev/disk/by-id name are generated
+instead.
+
+The changes against the existing file are shown before it is written.`,
+	Args:          cobra.NoArgs,
+	RunE:          generateVdevID,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+// generateMultipathCmd represents the generate multipath command
+var generateMultipathCmd = &cobra.Command{
+	Use:   "multipath",
+	Short: "Generate a multipath.conf with slot based aliases",
+	Long: `Generate a multipath.conf with a multipaths section aliasing every drive in
+an enclosure slot by WWID after its enclosure label and slot, ex: e03s17.
+Enclosures without a label are numbered from 1 in order of serial, ex: e01.
+
+With --blacklist-local, drives not in an enclosure, such as boot drives, are
+blacklisted by WWID. With --balance, each drive prefers the paths of one of
+its HBAs through the weightedpath prioritizer, spreading the active paths
+evenly across HBAs.
+
+The changes against the existing file are shown before it is written.`,
+	Args:          cobra.NoArgs,
+	RunE:          generateMultipath,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	RootCmd.AddCommand(generateCmd)
+	generateCmd.PersistentFlags().BoolVarP(&generateDryRun, "dry-run", "n", false, "Show the changes without writing the file")
+	generateCmd.PersistentFlags().BoolVarP(&generateYes, "yes", "y", false, "Confirm without prompting")
+
+	generateCmd.AddCommand(generateVdevIDCmd)
+	generateVdevIDCmd.Flags().StringVarP(&vdevIDFile, "file", "f", "/etc/zfs/vdev_id.conf", "File to write, empty to print to stdout")
+	generateVdevIDCmd.Flags().StringVar(&vdevIDMode, "mode", sastopo.VdevIDMultipath, "Topology mode: multipath or sas_direct")
+	generateVdevIDCmd.Flags().BoolVar(&vdevIDAliases, "aliases", false, "Generate alias lines for each slot instead of channels")
+
+	generateCmd.AddCommand(generateMultipathCmd)
+	generateMultipathCmd.Flags().StringVarP(&multipathFile, "file", "f", "/etc/multipath/conf.d/sastopo.conf", "File to write, empty to print to stdout")
+	generateMultipathCmd.Flags().BoolVar(&multipathOpts.BlacklistLocal, "blacklist-local", false, "Blacklist drives that are not in an enclosure")
+	generateMultipathCmd.Flags().BoolVar(&multipathOpts.Balance, "balance", false, "Spread active paths evenly across HBAs")
+}
+
+func generateVdevID(cmd *cobra.Command, args []string) error {
+	loadConf()
+
+	_, _, enclosures, _, err := sastopo.ScsiDevices(conf)
+	if err != nil {
+		return err
+	}
+
+	var content string
+	if vdevIDAliases {
+		content, err = sastopo.VdevIDAliases(enclosures, conf.EnclLabels)
+	} else {
+		content, err = sastopo.VdevIDConf(enclosures, vdevIDMode, conf.EnclLabels)
+	}
+	if err != nil {
+		return err
+	}
+	return writeGenerated(vdevIDFile, content, generateDryRun, generateYes)
+}
+
+func generateMultipath(cmd *cobra.Command, args []string) error {
+	loadConf()
+
+	_, multiPathDevices, enclosures, _, err := sastopo.ScsiDevices(conf)
+	if err != nil {
+		return err
+	}
+	content := sastopo.MultipathConf(multiPathDevices, enclosures, multipathOpts)
+	return writeGenerated(multipathFile, content, generateDryRun, generateYes)
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	sastopo "github.com/bensallen/sastopo/lib"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// hbaCmd represents the hba command
+var hbaCmd = &cobra.Command{
+	Use:   "hba",
+	Short: "Show HBA inventory",
+	Long: `Show the firmware, BIOS, driver, board and PCI details of each HBA, its
+PCIe link, NUMA node and AER error counters, and check them against policy.
+
+With --aer-state, AER counters are saved to a file so that counters which rose
+since the last run can be warned about. With --numa-node, HBAs on any other
+NUMA node than the one the drives are used from are warned about.`,
+	Args:          cobra.NoArgs,
+	RunE:          hbaInventory,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	RootCmd.AddCommand(hbaCmd)
+	hbaCmd.Flags().StringVar(&conf.AERStateFile, "aer-state", "", "File to save AER counters in between runs, ex: /var/lib/sastopo/aer.yaml")
+	hbaCmd.Flags().IntVar(&conf.NumaNode, "numa-node", -1, "Warn about HBAs not on this NUMA node")
+	hbaCmd.Flags().StringVar(&conf.MinHBAFirmware, "min-firmware", "", "Flag HBAs with firmware below this version, ex: 16.00.01.00")
+}
+
+func hbaInventory(cmd *cobra.Command, args []string) error {
+	loadConf()
+
+	_, _, _, HBAs, err := sastopo.ScsiDevices(conf)
+	if err != nil {
+		return err
+	}
+
+	previous, err := loadAERState(conf.AERStateFile)
+	if err != nil {
+		log.Printf("Warning: %s", err)
+	}
+	current := aerState{}
+
+	var problems, warnings []string
+	for _, hba := range sortedHBAs(HBAs) {
+		inv := hba.Inventory
+		fmt.Printf("HBA: %s, Slot: %s (%s), Host: %s\n", hba.PciID, hba.Slot, slotSource(hba), hba.Host)
+		fmt.Printf("    Board: %s, Assembly: %s, Tracer: %s\n", inv.BoardName, inv.BoardAssembly, inv.BoardTracer)
+		fmt.Printf("    Firmware: %s, BIOS: %s, NVDATA: %s\n", inv.FirmwareVersion, inv.BiosVersion, inv.NvdataVersion)
+		fmt.Printf("    Driver: %s, PCI ID: %s:%s, Subsystem: %s:%s\n", inv.Driver, inv.Vendor, inv.Device, inv.SubsystemVendor, inv.SubsystemDevice)
+		fmt.Printf("    SAS Address: %s, IOC Resets: %d, FW Queue Depth: %d\n", inv.SasAddress, inv.IOCResetCount, inv.FwQueueDepth)
+
+		link := hba.Link
+		fmt.Printf("    PCIe Link: %s x%d, Max: %s x%d\n", link.CurrentSpeed, link.CurrentWidth, link.MaxSpeed, link.MaxWidth)
+		fmt.Printf("    NUMA Node: %d, Local CPUs: %s\n", link.NumaNode, link.LocalCPUs)
+		fmt.Printf("    AER Errors: Correctable: %d, Non-Fatal: %d, Fatal: %d\n", link.AERCorrectable, link.AERNonFatal, link.AERFatal)
+		warnings = append(warnings, hba.LinkWarnings(conf.NumaNode)...)
+
+		current[hba.PciID] = map[string]int{
+			"correctable": link.AERCorrectable,
+			"nonfatal":    link.AERNonFatal,
+			"fatal":       link.AERFatal,
+		}
+		for _, counter := range []string{"correctable", "nonfatal", "fatal"} {
+			if last, ok := previous[hba.PciID][counter]; ok && current[hba.PciID][counter] > last {
+				warnings = append(warnings, fmt.Sprintf("HBA %s (%s) AER %s errors rose from %d to %d", hba.PciID, hba.Slot, counter, last, current[hba.PciID][counter]))
+			}
+		}
+
+		if hba.FirmwareBelow(conf.MinHBAFirmware) {
+			problems = append(problems, fmt.Sprintf("HBA %s (%s) firmware %s is below minimum %s", hba.PciID, hba.Slot, inv.FirmwareVersion, conf.MinHBAFirmware))
+		}
+	}
+
+	if err := saveAERState(conf.AERStateFile, current); err != nil {
+		log.Printf("Warning: %s", err)
+	}
+
+	for _, w := range warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+	for _, p := range problems {
+		fmt.Printf("Policy: %s\n", p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d HBA policy checks failed", len(problems))
+	}
+	return nil
+}
+
+// sortedHBAs returns the HBAs sorted by PCI ID
+func sortedHBAs(HBAs map[string]*sastopo.HBA) []*sastopo.HBA {
+	var hbas []*sastopo.HBA
+	for _, hba := range HBAs {
+		hbas = append(hbas, hba)
+	}
+	sort.Slice(hbas, func(i, j int) bool { return hbas[i].PciID < hbas[j].PciID })
+	return hbas
+}
+
+// aerState is the AER error counters of each HBA keyed by PCI ID
+type aerState map[string]map[string]int
+
+// loadAERState reads the AER counters saved by the last run, a missing file
+// is an empty state
+func loadAERState(file string) (aerState, error) {
+	state := aerState{}
+	if file == "" {
+		return state, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+	return state, yaml.Unmarshal(data, &state)
+}
+
+// saveAERState writes the AER counters for the next run to compare against
+func saveAERState(file string, state aerState) error {
+	if file == "" {
+		return nil
+	}
+	data, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	sastopo "github.com/bensallen/sastopo/lib"
+)
+
+var (
+	listOutput     []string
+	listPaths      bool
+	listFilter     string
+	listSort       []string
+	listNoHeadings bool
+)
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List parts of the SAS topology as tables",
+	Long:  "List parts of the SAS topology as tables",
+}
+
+// listDevicesCmd represents the list devices command
+var listDevicesCmd = &cobra.Command{
+	Use:   "devices",
+	Short: "List drives, one row per drive or per path",
+	Long: `List drives in a table, one row per multipath device, or per path with
+--paths, sorted by physical location unless --sort is given.
+
+--filter selects rows by comparing columns with values, joined by && and ||,
+ex: --filter 'enclosure=JBOD3 && paths<2'. Comparisons are = and != ignoring
+case, <, <=, > and >= as numbers or sizes, and =~ and !~ regular expressions.
+
+Columns:
+` + listColumnHelp(),
+	Args:          cobra.NoArgs,
+	RunE:          listDevices,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	RootCmd.AddCommand(listCmd)
+	listCmd.AddCommand(listDevicesCmd)
+	listDevicesCmd.Flags().StringSliceVarP(&listOutput, "output", "o", sastopo.DefaultListColumns, "Columns to show, ex: name,serial,enclosure,slot")
+	listDevicesCmd.Flags().BoolVar(&listPaths, "paths", false, "Show one row per path instead of per drive")
+	listDevicesCmd.Flags().StringVarP(&listFilter, "filter", "f", "", "Only show rows matching the filter, ex: 'encl=JBOD3 && paths<2'")
+	listDevicesCmd.Flags().StringSliceVarP(&listSort, "sort", "s", nil, "Columns to sort by, default enclosure, slot and serial")
+	listDevicesCmd.Flags().BoolVarP(&listNoHeadings, "noheadings", "n", false, "Don't print the column headings")
+	listDevicesCmd.Flags().IntVarP(&conf.PathCount, "pathcount", "p", 2, "Number of expected paths to each SAS device")
+}
+
+// listColumnHelp describes each column for the command help
+func listColumnHelp() string {
+	var lines []string
+	for _, c := range sastopo.ListColumns {
+		lines = append(lines, fmt.Sprintf("  %-7s %s", c.Name, c.Help))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func listDevices(cmd *cobra.Command, args []string) error {
+	var columns []string
+	for _, name := range listOutput {
+		column, err := sastopo.ListColumnName(name)
+		if err != nil {
+			return err
+		}
+		columns = append(columns, column)
+	}
+	filter, err := sastopo.ParseListFilter(listFilter)
+	if err != nil {
+		return err
+	}
+	loadConf()
+
+	_, multiPathDevices, enclosures, HBAs, err := sastopo.ScsiDevices(conf)
+	if err != nil {
+		return err
+	}
+
+	rows := sastopo.ListRows(sastopo.NewView(multiPathDevices, enclosures, HBAs, conf.PathCount), listPaths)
+	if err := sastopo.SortListRows(rows, listSort); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	if !listNoHeadings {
+		fmt.Fprintln(w, strings.Join(columns, "\t"))
+	}
+	for _, row := range rows {
+		if !filter.Match(row) {
+			continue
+		}
+		values := make([]string, len(columns))
+		for i, c := range columns {
+			values[i] = row[c]
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	sastopo "github.com/bensallen/sastopo/lib"
+)
+
+var (
+	planVdevType   string
+	planWidth      int
+	planPool       string
+	planEnclosures []string
+)
+
+// planCmd represents the plan command
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Plan storage layouts from the SAS topology",
+}
+
+// planZfsCmd represents the plan zfs command
+var planZfsCmd = &cobra.Command{
+	Use:   "zfs",
+	Short: "Plan a ZFS pool spread across failure domains",
+	Long: `Lay out the drives in enclosure slots as ZFS vdevs, spreading each vdev's
+members across enclosures, then drawers, then HBAs, as evenly as possible, and
+print a zpool create command using /dev/disk/by-id names. Drives left over are
+added as spares.
+
+dRAID vdev types take zpool's data and distributed spare counts, ex:
+--vdev-type draid2:8d:2s --width 14, the width is the number of children.
+
+Each vdev is followed by a comment listing the failure domains it survives
+losing any one of, compared with its parity: enclosures, drawers, HBAs and
+enclosure IOMs.`,
+	Args:          cobra.NoArgs,
+	RunE:          planZfs,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	RootCmd.AddCommand(planCmd)
+	planCmd.AddCommand(planZfsCmd)
+	planZfsCmd.Flags().StringVar(&planVdevType, "vdev-type", "raidz2", "Vdev type: mirror, raidz1-3 or draid1-3[:<data>d][:<spares>s]")
+	planZfsCmd.Flags().IntVar(&planWidth, "width", 10, "Members per vdev")
+	planZfsCmd.Flags().StringVar(&planPool, "pool", "tank", "Pool name")
+	planZfsCmd.Flags().StringSliceVar(&planEnclosures, "enclosure", nil, "Only use drives in the enclosures with these serials, labels or logical IDs")
+}
+
+func planZfs(cmd *cobra.Command, args []string) error {
+	loadConf()
+
+	_, _, enclosures, _, err := sastopo.ScsiDevices(conf)
+	if err != nil {
+		return err
+	}
+
+	var mpds []*sastopo.MultiPathDevice
+	for enclosure := range enclosures {
+		if len(planEnclosures) > 0 && !matchesAny(enclosure, planEnclosures) {
+			continue
+		}
+		for _, mpd := range enclosure.Slots {
+			mpds = append(mpds, mpd)
+		}
+	}
+
+	plan, err := sastopo.PlanZfs(mpds, planVdevType, planWidth)
+	if err != nil {
+		return err
+	}
+	names := sastopo.PersistentNames(mpds)
+
+	fmt.Printf("zpool create %s", planPool)
+	for _, vdev := range plan.Vdevs {
+		fmt.Printf(" \\\n    %s %s", vdev.Type, memberNames(vdev.Members, names))
+	}
+	if len(plan.Spares) > 0 {
+		fmt.Printf(" \\\n    spare %s", memberNames(plan.Spares, names))
+	}
+	fmt.Printf("\n\n")
+
+	for i, vdev := range plan.Vdevs {
+		fmt.Printf("# vdev %d (%s, survives %d failures): %s\n", i+1, vdev.Type, vdev.Parity, explainVdev(vdev))
+	}
+	return nil
+}
+
+// memberNames joins the persistent names of the devices
+func memberNames(mpds []*sastopo.MultiPathDevice, names map[*sastopo.MultiPathDevice]string) string {
+	var n []string
+	for _, mpd := range mpds {
+		n = append(n, names[mpd])
+	}
+	return strings.Join(n, " ")
+}
+
+// explainVdev describes which failure domains the vdev survives losing one of
+func explainVdev(vdev *sastopo.Vdev) string {
+	var tolerates, not []string
+	for _, domain := range sastopo.Domains {
+		counts := vdev.DomainCounts(domain)
+		// Only enclosures with drawers have drawer domains
+		if domain == sastopo.DomainDrawer && len(counts) == 0 {
+			continue
+		}
+		// IOMs are only known when the enclosure reports them over SES
+		if domain == sastopo.DomainIOM && len(counts) == 0 && !knownIOMs(vdev) {
+			continue
+		}
+		if vdev.Tolerates(domain) {
+			tolerates = append(tolerates, fmt.Sprintf("%s (%s)", domain, joinCounts(counts)))
+		} else {
+			not = append(not, fmt.Sprintf("%s (%s)", domain, joinCounts(counts)))
+		}
+	}
+	s := "tolerates losing any one " + strings.Join(tolerates, ", ")
+	if len(tolerates) == 0 {
+		s = "tolerates losing no whole domain"
+	}
+	if len(not) > 0 {
+		s += "; not " + strings.Join(not, ", ")
+	}
+	return s
+}
+
+// knownIOMs returns true if the IOM of every path of every member is known
+func knownIOMs(vdev *sastopo.Vdev) bool {
+	for _, mpd := range vdev.Members {
+		for device := range mpd.Paths {
+			if device.IOM == "" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// joinCounts formats the members per domain value, ex: SN1:4 SN2:3
+func joinCounts(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "no single point"
+	}
+	var keys []string
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var s []string
+	for _, k := range keys {
+		s = append(s, fmt.Sprintf("%s:%d", k, counts[k]))
+	}
+	return strings.Join(s, " ")
+}
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	sastopo "github.com/bensallen/sastopo/lib"
+)
+
+var (
+	replaceYes           bool
+	replaceTimeout       time.Duration
+	replaceRemoveTimeout time.Duration
+)
+
+// replaceCmd represents the replace command
+var replaceCmd = &cobra.Command{
+	Use:   "replace <serial|sdX|enclosure:slot>",
+	Short: "Guide the replacement of a drive",
+	Long: `Guide the replacement of a drive: find its slot, check its paths, turn on
+the slot's locate LED, delete its SCSI devices, wait for the new drive and
+verify it.
+
+A drive that failed and dropped out of the topology can be given as its
+empty bay, ex: JBOD3:17, in which case there are no paths to check or SCSI
+devices to delete.
+
+Without --yes each step is confirmed interactively. With --yes no questions
+are asked and the new drive is waited for until the timeout expires.`,
+	Args:          cobra.ExactArgs(1),
+	RunE:          replace,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	RootCmd.AddCommand(replaceCmd)
+	replaceCmd.Flags().BoolVarP(&replaceYes, "yes", "y", false, "Run without prompting, for use in scripts")
+	replaceCmd.Flags().DurationVarP(&replaceTimeout, "timeout", "t", 30*time.Minute, "Time to wait for the new drive")
+	replaceCmd.Flags().DurationVar(&replaceRemoveTimeout, "remove-timeout", time.Minute, "Time to wait for the old drive's SCSI devices to go")
+	replaceCmd.Flags().IntVarP(&conf.PathCount, "pathcount", "p", 2, "Number of expected paths to each SAS device")
+}
+
+func replace(cmd *cobra.Command, args []string) error {
+	loadConf()
+
+	_, multiPathDevices, enclosures, _, err := sastopo.ScsiDevices(conf)
+	if err != nil {
+		return err
+	}
+	target, err := sastopo.ResolveTarget(args[0], multiPathDevices, enclosures)
+	if err != nil {
+		return err
+	}
+	if target.Enclosure == nil {
+		return fmt.Errorf("%s is not in an enclosure slot", args[0])
+	}
+	if target.Device == nil && target.Element() == nil {
+		return fmt.Errorf("no device or bay found for %s", args[0])
+	}
+	old := target.Device
+	encl, name := target.Enclosure.Serial(), target.Enclosure.Name()
+
+	fmt.Printf("Step 1: Found %s\n", target)
+	if old == nil {
+		fmt.Printf("    Empty bay, skipping the old drive's paths and SCSI devices\n")
+	} else {
+		fmt.Printf("    Vendor: %s, Model: %s, Firmware: %s\n", old.Vendor(), old.Model(), old.Rev())
+		if err := checkOldDrive(old); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Step 3: Turning on locate LED\n")
+	identOn, err := target.Enclosure.SlotControl(target.Slot, sastopo.SlotIdent, true)
+	if err != nil {
+		return err
+	}
+	identOff, err := target.Enclosure.SlotControl(target.Slot, sastopo.SlotIdent, false)
+	if err != nil {
+		return err
+	}
+	if err := identOn.Run(); err != nil {
+		return err
+	}
+	defer func() {
+		if err := identOff.Run(); err != nil {
+			fmt.Printf("Warning: %s\n", err)
+		}
+	}()
+
+	if old != nil {
+		fmt.Printf("Step 4: Deleting SCSI devices\n")
+		if !replaceYes && !confirm("Delete the SCSI devices of "+old.Serial()+"?") {
+			return errors.New("aborted")
+		}
+		if err := old.Delete(); err != nil {
+			return err
+		}
+		if err := old.WaitRemoved(replaceRemoveTimeout); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Step 5: Replace the drive in enclosure %s slot %d", name, target.Slot)
+	if el := target.Element(); el != nil && el.Descriptor != "" {
+		fmt.Printf(" (%s)", el.Descriptor)
+	}
+	fmt.Printf(", the locate LED is on\n")
+	if !replaceYes {
+		fmt.Printf("Press Enter once the new drive is inserted: ")
+		if _, err := bufio.NewReader(os.Stdin).ReadString('\n'); err != nil {
+			return fmt.Errorf("aborted, reading stdin failed: %s", err)
+		}
+	}
+
+	fmt.Printf("Step 6: Waiting for the new drive\n")
+	ids, err := target.Enclosure.WaitSlotDevices(target.Slot, replaceTimeout)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("    Found SCSI devices: %s\n", strings.Join(ids, ", "))
+
+	// Give the remaining paths a moment to arrive before rediscovering
+	if len(ids) < conf.PathCount {
+		time.Sleep(10 * time.Second)
+	}
+
+	fmt.Printf("Step 7: Verifying the new drive\n")
+	_, multiPathDevices, enclosures, _, err = sastopo.ScsiDevices(conf)
+	if err != nil {
+		return err
+	}
+	target, err = sastopo.ResolveTarget(fmt.Sprintf("%s:%d", encl, target.Slot), multiPathDevices, enclosures)
+	if err != nil {
+		return err
+	}
+	if target.Device == nil {
+		return fmt.Errorf("no device found in enclosure %s slot %d", name, target.Slot)
+	}
+	return verifyReplacement(old, target)
+}
+
+// checkOldDrive prints the paths of the drive being replaced and refuses to
+// go on if it is still in use
+func checkOldDrive(old *sastopo.MultiPathDevice) error {
+	fmt.Printf("Step 2: Checking paths\n")
+	for _, path := range old.Devices() {
+		fmt.Printf("    HBA: %s, Port: %s, SG: %s, Device: %s\n", path.HBA.Slot, path.Port, path.SG, path.Block)
+	}
+	if len(old.Paths) != conf.PathCount {
+		fmt.Printf("    Warning: found %d paths, expected %d\n", len(old.Paths), conf.PathCount)
+	}
+	// Device-mapper or md devices built on the paths must go first
+	if holders := old.Holders(); len(holders) > 0 {
+		return fmt.Errorf("refusing to replace %s, it is still in use by %s, remove those first (ex: multipath -f)", old.Serial(), strings.Join(holders, ", "))
+	}
+	mounts, err := old.SoleMounts()
+	if err != nil {
+		return err
+	}
+	if len(mounts) > 0 {
+		return fmt.Errorf("refusing to replace %s, it is the last remaining path of mounted filesystems: %s", old.Serial(), strings.Join(mounts, ", "))
+	}
+	return nil
+}
+
+// verifyReplacement prints a report on the new drive in target, comparing
+// it with the old drive if there was one
+func verifyReplacement(old *sastopo.MultiPathDevice, target *sastopo.Target) error {
+	var problems []string
+
+	mpd := target.Device
+	fmt.Printf("    %s\n", target)
+	fmt.Printf("    Vendor: %s, Model: %s, Firmware: %s\n", mpd.Vendor(), mpd.Model(), mpd.Rev())
+	fmt.Printf("    Paths: %d\n", len(mpd.Paths))
+	for _, path := range mpd.Devices() {
+		fmt.Printf("        HBA: %s, Port: %s, SG: %s, Device: %s\n", path.HBA.Slot, path.Port, path.SG, path.Block)
+	}
+
+	if len(mpd.Paths) != conf.PathCount {
+		problems = append(problems, fmt.Sprintf("found %d paths, expected %d", len(mpd.Paths), conf.PathCount))
+	}
+	if old != nil {
+		if mpd.Serial() == old.Serial() {
+			problems = append(problems, "serial matches the old drive, it was not replaced")
+		}
+		if mpd.Model() != old.Model() {
+			problems = append(problems, fmt.Sprintf("model %s differs from the old drive's %s", mpd.Model(), old.Model()))
+		}
+		if mpd.Rev() != old.Rev() {
+			fmt.Printf("    Note: firmware %s differs from the old drive's %s\n", mpd.Rev(), old.Rev())
+		}
+	}
+
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Printf("    Problem: %s\n", p)
+		}
+		return errors.New("replacement drive failed verification")
+	}
+	fmt.Printf("    Replacement verified\n")
+	return nil
+}
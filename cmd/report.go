@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	sastopo "github.com/bensallen/sastopo/lib"
+)
+
+var reportHTML string
+
+// reportCmd represents the report command
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Write a report of the host's storage topology",
+	Long: `Write a single self-contained HTML file, with no external assets, reporting
+the HBA inventory, enclosure health, a bay map of each enclosure, multipath
+status, SAS phy error counters, and the warnings and diagnostics found during
+discovery. Intended for audits and vendor cases.`,
+	Args:          cobra.NoArgs,
+	RunE:          report,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	RootCmd.AddCommand(reportCmd)
+	reportCmd.Flags().StringVar(&reportHTML, "html", "", "HTML file to write the report to")
+	reportCmd.Flags().IntVarP(&conf.PathCount, "pathcount", "p", 2, "Number of expected paths to each SAS device")
+	reportCmd.Flags().IntVar(&conf.NumaNode, "numa-node", -1, "Warn about HBAs not on this NUMA node")
+}
+
+// reportPhy is an HBA phy with error counters
+type reportPhy struct {
+	HBA    string
+	Port   string
+	Phy    *sastopo.Phy
+	Errors sastopo.PhyErrors
+}
+
+// reportEnclosure is an enclosure with its SES elements that aren't OK and
+// its bay map
+type reportEnclosure struct {
+	*sastopo.Enclosure
+	Paths     int
+	Unhealthy []*sastopo.SesElement
+	Layout    template.HTML
+}
+
+// reportDrive is a drive with its paths and multipath status
+type reportDrive struct {
+	Location string
+	MPD      *sastopo.MultiPathDevice
+	Paths    []*sastopo.Device
+	Status   string
+}
+
+// reportData is the data the report template is executed with
+type reportData struct {
+	Host        string
+	Generated   string
+	Warnings    []string
+	Diagnostics []string
+	HBAs        []*sastopo.HBA
+	Phys        []reportPhy
+	Enclosures  []reportEnclosure
+	Drives      []reportDrive
+}
+
+func report(cmd *cobra.Command, args []string) error {
+	if reportHTML == "" {
+		return errors.New("--html is required")
+	}
+	loadConf()
+
+	devices, multiPathDevices, enclosures, HBAs, err := sastopo.ScsiDevices(conf)
+	if err != nil {
+		return err
+	}
+
+	data := reportData{
+		Generated:   time.Now().Format(time.RFC1123),
+		Warnings:    sastopo.Warnings(),
+		Diagnostics: sastopo.Diagnostics(),
+	}
+	data.Host, _ = os.Hostname()
+
+	data.HBAs = sortedHBAs(HBAs)
+	for _, hba := range data.HBAs {
+		data.Warnings = append(data.Warnings, hba.LinkWarnings(conf.NumaNode)...)
+		for port := range hba.Ports {
+			for phy := range port.Phys {
+				if phy.Errors.Total() > 0 {
+					data.Phys = append(data.Phys, reportPhy{HBA: hba.Name(), Port: port.PortID, Phy: phy, Errors: phy.Errors})
+				}
+			}
+		}
+	}
+	sort.Slice(data.Phys, func(i, j int) bool {
+		a, b := data.Phys[i], data.Phys[j]
+		if a.HBA != b.HBA {
+			return a.HBA < b.HBA
+		}
+		x, _ := strconv.Atoi(a.Phy.PhyIdentifier)
+		y, _ := strconv.Atoi(b.Phy.PhyIdentifier)
+		return x < y
+	})
+	data.Warnings = append(data.Warnings, sastopo.DMMismatches(multiPathDevices)...)
+	data.Warnings = append(data.Warnings, sastopo.IOMMismatches(multiPathDevices)...)
+	data.Warnings = append(data.Warnings, sastopo.CheckCabling(sastopo.Chains(enclosures), enclosures, conf.Cabling)...)
+	for _, finding := range sastopo.Reconcile(devices, enclosures) {
+		data.Warnings = append(data.Warnings, finding.String())
+	}
+
+	var sorted []*sastopo.Enclosure
+	for enclosure := range enclosures {
+		sorted = append(sorted, enclosure)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name() < sorted[j].Name() })
+	for _, enclosure := range sorted {
+		e := reportEnclosure{
+			Enclosure: enclosure,
+			Paths:     len(enclosure.MultiPathDevice.Paths),
+			Layout:    template.HTML(sastopo.LayoutSVG(enclosure.BayGrids(conf.Layouts, conf.PathCount))),
+		}
+		for _, el := range enclosure.Elements {
+			if !el.IsSlot() && (el.PrdFail || el.Status == sastopo.SesStatusCritical ||
+				el.Status == sastopo.SesStatusNoncritical || el.Status == sastopo.SesStatusUnrecoverable) {
+				e.Unhealthy = append(e.Unhealthy, el)
+			}
+		}
+		data.Enclosures = append(data.Enclosures, e)
+	}
+
+	for _, mpd := range uniqueMultiPathDevices(multiPathDevices) {
+		d := reportDrive{MPD: mpd, Status: sastopo.BayOK}
+		for _, device := range mpd.Devices() {
+			if device.Type == 0 {
+				d.Paths = append(d.Paths, device)
+			}
+		}
+		if len(d.Paths) == 0 {
+			continue
+		}
+		sort.Slice(d.Paths, func(i, j int) bool { return d.Paths[i].ID < d.Paths[j].ID })
+		if t := mpd.Target(); t.Enclosure != nil {
+			d.Location = fmt.Sprintf("%s:%d", t.Enclosure.Name(), t.Slot)
+			d.Status = t.Enclosure.BayState(t.Slot, conf.PathCount)
+		} else if len(d.Paths) < conf.PathCount {
+			d.Status = sastopo.BayDegraded
+		}
+		data.Drives = append(data.Drives, d)
+	}
+	sort.Slice(data.Drives, func(i, j int) bool {
+		if data.Drives[i].Location != data.Drives[j].Location {
+			return data.Drives[i].Location < data.Drives[j].Location
+		}
+		return data.Drives[i].MPD.Serial() < data.Drives[j].MPD.Serial()
+	})
+
+	var b bytes.Buffer
+	if err := reportTemplate.Execute(&b, data); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(reportHTML, b.Bytes(), 0644)
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>sastopo report: {{.Host}}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #c0c0c0; padding: 3px 8px; text-align: left; vertical-align: top; }
+th { background: #f0f0f0; }
+.bad { color: #c00000; }
+</style>
+</head>
+<body>
+<h1>Storage topology of {{.Host}}</h1>
+<p>Generated {{.Generated}}</p>
+
+<h2>Warnings and diagnostics</h2>
+{{if or .Warnings .Diagnostics}}<ul>
+{{range .Warnings}}<li class="bad">{{.}}</li>
+{{end}}{{range .Diagnostics}}<li>{{.}}</li>
+{{end}}</ul>{{else}}<p>None</p>{{end}}
+
+<h2>HBAs</h2>
+<table>
+<tr><th>Slot</th><th>PCI ID</th><th>Host</th><th>Board</th><th>Firmware</th><th>BIOS</th><th>Driver</th><th>PCIe link</th><th>NUMA node</th><th>AER errors</th></tr>
+{{range .HBAs}}<tr><td>{{.Slot}}</td><td>{{.PciID}}</td><td>{{.Host}}</td><td>{{.Inventory.BoardName}}</td><td>{{.Inventory.FirmwareVersion}}</td><td>{{.Inventory.BiosVersion}}</td><td>{{.Inventory.Driver}}</td><td>{{.Link.CurrentSpeed}} x{{.Link.CurrentWidth}} of {{.Link.MaxSpeed}} x{{.Link.MaxWidth}}</td><td>{{.Link.NumaNode}}</td><td>{{.Link.AERCorrectable}} correctable, {{.Link.AERNonFatal}} non-fatal, {{.Link.AERFatal}} fatal</td></tr>
+{{end}}</table>
+
+<h2>Phy errors</h2>
+{{if .Phys}}<table>
+<tr><th>HBA</th><th>Port</th><th>Phy</th><th>Link rate</th><th>Invalid dword</th><th>Running disparity</th><th>Loss of dword sync</th><th>Phy reset problem</th></tr>
+{{range .Phys}}<tr><td>{{.HBA}}</td><td>{{.Port}}</td><td>{{.Phy.PhyIdentifier}}</td><td>{{.Phy.LinkRate}}</td><td>{{.Errors.InvalidDword}}</td><td>{{.Errors.RunningDisparity}}</td><td>{{.Errors.LossOfDwordSync}}</td><td>{{.Errors.PhyResetProblem}}</td></tr>
+{{end}}</table>{{else}}<p>No HBA phy errors</p>{{end}}
+
+<h2>Enclosures</h2>
+<table>
+<tr><th>Name</th><th>Serial</th><th>Vendor</th><th>Model</th><th>Logical ID</th><th>Rack</th><th>U</th><th>Paths</th><th>Slots populated</th><th>Health</th></tr>
+{{range .Enclosures}}<tr><td>{{.Name}}</td><td>{{.Serial}}</td><td>{{.Vendor}}</td><td>{{.Model}}</td><td>{{.LogicalID}}</td><td>{{.Rack}}</td><td>{{.U}}</td><td>{{.Paths}}</td><td>{{.PopulatedSlots}} of {{.TotalSlots}}</td><td>{{if .Unhealthy}}{{range .Unhealthy}}<div class="bad">{{if .Descriptor}}{{.Descriptor}}{{else}}Element {{.Index}}{{end}}: {{.StatusString}}</div>{{end}}{{else}}OK{{end}}</td></tr>
+{{end}}</table>
+
+<h2>Bay maps</h2>
+{{range .Enclosures}}<div>{{.Layout}}</div>
+{{end}}
+
+<h2>Multipath status</h2>
+<table>
+<tr><th>Location</th><th>Serial</th><th>Model</th><th>Multipath map</th><th>Paths</th><th>Status</th></tr>
+{{range .Drives}}<tr><td>{{.Location}}</td><td>{{.MPD.Serial}}</td><td>{{.MPD.Vendor}} {{.MPD.Model}}</td><td>{{with .MPD.DM}}{{.Map}} ({{.Name}}){{end}}</td><td>{{range .Paths}}<div>{{.Block}} {{.ID}} {{with .HBA}}{{.Slot}}{{end}} {{.State}}{{if .DMState}}, {{.DMState}}{{end}}{{if .IOM}}, {{.IOM}}{{end}}</div>{{end}}</td><td{{if ne .Status "ok"}} class="bad"{{end}}>{{.Status}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	sastopo "github.com/bensallen/sastopo/lib"
+)
+
+var (
+	rescanHBA       string
+	rescanEnclosure string
+	removeYes       bool
+	removeTimeout   time.Duration
+)
+
+// rescanCmd represents the rescan command
+var rescanCmd = &cobra.Command{
+	Use:   "rescan",
+	Short: "Rescan SCSI hosts for new devices",
+	Long: `Rescan the SCSI hosts of all HBAs, one HBA or the HBAs connected to an
+enclosure for new devices, then show how the topology changed.`,
+	Args:          cobra.NoArgs,
+	RunE:          rescan,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+// removeCmd represents the remove command
+var removeCmd = &cobra.Command{
+	Use:   "remove <target>",
+	Short: "Remove every path of a device from the kernel",
+	Long: `Remove every path of a multipath device from the kernel by writing to each
+path's device/delete, then show how the topology changed. Multipath maps built
+on the paths are flushed first.
+
+A target is a device serial, SAS address, SCSI ID, sdX or sgN device, or an
+enclosure and slot as <enclosure>:<slot>, by enclosure serial, label or
+logical ID.`,
+	Args:          cobra.ExactArgs(1),
+	RunE:          remove,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	RootCmd.AddCommand(rescanCmd)
+	rescanCmd.Flags().StringVar(&rescanHBA, "hba", "", "Only rescan the HBA with this slot label or PCI ID")
+	rescanCmd.Flags().StringVar(&rescanEnclosure, "enclosure", "", "Only rescan the HBAs connected to the enclosure with this serial, label or logical ID")
+
+	RootCmd.AddCommand(removeCmd)
+	removeCmd.Flags().BoolVarP(&removeYes, "yes", "y", false, "Confirm without prompting")
+	removeCmd.Flags().DurationVar(&removeTimeout, "timeout", time.Minute, "How long to wait for the paths to go away")
+}
+
+func rescan(cmd *cobra.Command, args []string) error {
+	loadConf()
+
+	_, multiPathDevices, enclosures, HBAs, err := sastopo.ScsiDevices(conf)
+	if err != nil {
+		return err
+	}
+	before := topologyLines(multiPathDevices, enclosures)
+
+	hosts := map[*sastopo.HBA]bool{}
+	switch {
+	case rescanHBA != "":
+		hba := findHBA(rescanHBA, HBAs)
+		if hba == nil {
+			return fmt.Errorf("HBA %s not found", rescanHBA)
+		}
+		hosts[hba] = true
+	case rescanEnclosure != "":
+		found := false
+		for enclosure := range enclosures {
+			if !enclosure.Matches(rescanEnclosure) {
+				continue
+			}
+			found = true
+			for device := range enclosure.MultiPathDevice.Paths {
+				if device.HBA != nil {
+					hosts[device.HBA] = true
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("enclosure %s not found", rescanEnclosure)
+		}
+		if len(hosts) == 0 {
+			return fmt.Errorf("no HBA found for enclosure %s", rescanEnclosure)
+		}
+	default:
+		for _, hba := range HBAs {
+			hosts[hba] = true
+		}
+	}
+
+	var scan []*sastopo.HBA
+	for hba := range hosts {
+		scan = append(scan, hba)
+	}
+	sort.Slice(scan, func(i, j int) bool { return scan[i].Host < scan[j].Host })
+	for _, hba := range scan {
+		fmt.Printf("Scanning %s (HBA: %s, Slot: %s)\n", hba.Host, hba.PciID, hba.Slot)
+		if err := hba.Scan(); err != nil {
+			return fmt.Errorf("scanning %s failed: %s", hba.Host, err)
+		}
+	}
+
+	_, multiPathDevices, enclosures, _, err = sastopo.ScsiDevices(conf)
+	if err != nil {
+		return err
+	}
+	printTopologyDiff(before, topologyLines(multiPathDevices, enclosures))
+	return nil
+}
+
+func remove(cmd *cobra.Command, args []string) error {
+	loadConf()
+
+	_, multiPathDevices, enclosures, _, err := sastopo.ScsiDevices(conf)
+	if err != nil {
+		return err
+	}
+	before := topologyLines(multiPathDevices, enclosures)
+
+	target, err := sastopo.ResolveTarget(args[0], multiPathDevices, enclosures)
+	if err != nil {
+		return err
+	}
+	if target.Device == nil {
+		return fmt.Errorf("no device found for %s", args[0])
+	}
+	mpd := target.Device
+
+	// Multipath maps built on the paths are flushed first, anything else,
+	// such as an md array, must be removed by hand
+	maps, err := mpd.MultipathMaps()
+	if err != nil {
+		return fmt.Errorf("refusing to remove: %s", err)
+	}
+	mounts, err := mpd.SoleMounts()
+	if err != nil {
+		return err
+	}
+	if len(mounts) > 0 {
+		return fmt.Errorf("refusing to remove %s, it is the last remaining path of mounted filesystems: %s", mpd.Serial(), strings.Join(mounts, ", "))
+	}
+
+	fmt.Printf("Removing %s\n", target)
+	for _, name := range maps {
+		fmt.Printf("    Multipath map: %s\n", name)
+	}
+	for _, device := range mpd.Devices() {
+		fmt.Printf("    SCSI ID: %s, HBA: %s, Port: %s, Device: %s\n", device.ID, device.HBA.Slot, device.Port, device.Block)
+	}
+	if !removeYes && !confirm("Remove these paths?") {
+		return errors.New("aborted")
+	}
+	for _, name := range maps {
+		if err := sastopo.FlushMultipath(name); err != nil {
+			return err
+		}
+	}
+	if err := mpd.Delete(); err != nil {
+		return err
+	}
+	if err := mpd.WaitRemoved(removeTimeout); err != nil {
+		return err
+	}
+
+	_, multiPathDevices, enclosures, _, err = sastopo.ScsiDevices(conf)
+	if err != nil {
+		return err
+	}
+	printTopologyDiff(before, topologyLines(multiPathDevices, enclosures))
+	return nil
+}
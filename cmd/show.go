@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	sastopo "github.com/bensallen/sastopo/lib"
+)
+
+var (
+	showLayout bool
+	showFormat string
+)
+
+// showCmd represents the show command
+var showCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show details of part of the SAS topology",
+	Long:  "Show details of part of the SAS topology",
+}
+
+// showEnclosureCmd represents the show enclosure command
+var showEnclosureCmd = &cobra.Command{
+	Use:   "enclosure [<enclosure>...]",
+	Short: "Show the bays of enclosures",
+	Long: `Show the bays of the given enclosures, by serial, label or logical ID, or of
+every enclosure if none are given.
+
+With --layout the bays are drawn in their physical arrangement, one grid per
+drawer for enclosures with drawers, marking empty, healthy, faulted, path
+degraded and locate on bays. Layouts of known models are built in, and
+Layouts in the config file, keyed by enclosure model, give or override them.
+Enclosures of other models are drawn in rows of 12.
+
+--format svg draws the layout as an SVG image for reports instead of ASCII.`,
+	RunE:          showEnclosure,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	RootCmd.AddCommand(showCmd)
+	showCmd.AddCommand(showEnclosureCmd)
+	showEnclosureCmd.Flags().BoolVarP(&showLayout, "layout", "l", false, "Draw the bays in their physical arrangement")
+	showEnclosureCmd.Flags().StringVar(&showFormat, "format", "ascii", "Layout format: ascii or svg")
+	showEnclosureCmd.Flags().IntVarP(&conf.PathCount, "pathcount", "p", 2, "Number of expected paths to each SAS device")
+}
+
+func showEnclosure(cmd *cobra.Command, args []string) error {
+	if showFormat != "ascii" && showFormat != "svg" {
+		return fmt.Errorf("unknown layout format %s, expected ascii or svg", showFormat)
+	}
+	loadConf()
+
+	_, _, enclosures, _, err := sastopo.ScsiDevices(conf)
+	if err != nil {
+		return err
+	}
+
+	var selected []*sastopo.Enclosure
+	for enclosure := range enclosures {
+		if len(args) == 0 || matchesAny(enclosure, args) {
+			selected = append(selected, enclosure)
+		}
+	}
+	if len(selected) == 0 {
+		return errors.New("no matching enclosures found")
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Name() < selected[j].Name() })
+
+	if !showLayout {
+		for _, enclosure := range selected {
+			printBays(enclosure)
+		}
+		return nil
+	}
+
+	var grids []*sastopo.BayGrid
+	for _, enclosure := range selected {
+		grids = append(grids, enclosure.BayGrids(conf.Layouts, conf.PathCount)...)
+	}
+	if showFormat == "svg" {
+		fmt.Print(sastopo.LayoutSVG(grids))
+	} else {
+		fmt.Print(sastopo.LayoutASCII(grids))
+	}
+	return nil
+}
+
+// printBays lists the enclosure's bays with their state and drive
+func printBays(enclosure *sastopo.Enclosure) {
+	fmt.Printf("Enclosure: %s, Serial: %s, Model: %s, Slots %d of %d populated\n",
+		enclosure.Name(), enclosure.Serial(), enclosure.Model(), enclosure.PopulatedSlots(), enclosure.TotalSlots())
+	for _, grid := range enclosure.BayGrids(conf.Layouts, conf.PathCount) {
+		for _, bay := range grid.Bays {
+			fmt.Printf("    Slot: %d, State: %s", bay.Slot, bay.State)
+			if grid.Name != "" {
+				fmt.Printf(", Drawer: %s", grid.Name)
+			}
+			if bay.Label != "" {
+				fmt.Printf(", Label: %s", bay.Label)
+			}
+			if bay.Device != nil {
+				fmt.Printf(", Serial: %s", bay.Device.Serial())
+			}
+			fmt.Printf("\n")
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	sastopo "github.com/bensallen/sastopo/lib"
+)
+
+var (
+	slotDryRun  bool
+	slotYes     bool
+	slotTimeout time.Duration
+)
+
+// slotCmd represents the slot command
+var slotCmd = &cobra.Command{
+	Use:   "slot",
+	Short: "Control enclosure slots",
+	Long:  "Control enclosure slots through their SES device slot elements",
+}
+
+// slotPowerCmd represents the slot power command
+var slotPowerCmd = &cobra.Command{
+	Use:   "power off|on|cycle <target>",
+	Short: "Power off, on or cycle an enclosure slot",
+	Long: `Power off, on or cycle an enclosure slot by setting or clearing the device
+off bit of its SES device slot element, then wait for the device to leave
+or return in sysfs.
+
+A target is a device serial, SAS address, SCSI ID, sdX or sgN device, or an
+enclosure and slot as <enclosure>:<slot>, by enclosure serial, label or
+logical ID. A powered off drive can only be targeted by enclosure and slot.`,
+	Args:          cobra.ExactArgs(2),
+	RunE:          slotPower,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	RootCmd.AddCommand(slotCmd)
+	slotCmd.AddCommand(slotPowerCmd)
+	slotPowerCmd.Flags().BoolVarP(&slotDryRun, "dry-run", "n", false, "Show what would be done without doing it")
+	slotPowerCmd.Flags().BoolVarP(&slotYes, "yes", "y", false, "Confirm without prompting")
+	slotPowerCmd.Flags().DurationVarP(&slotTimeout, "timeout", "t", 2*time.Minute, "Time to wait for the device to leave or return")
+}
+
+func slotPower(cmd *cobra.Command, args []string) error {
+	action := args[0]
+	if action != "off" && action != "on" && action != "cycle" {
+		return fmt.Errorf("unknown slot power action %q, expected off, on or cycle", action)
+	}
+	loadConf()
+
+	_, multiPathDevices, enclosures, _, err := sastopo.ScsiDevices(conf)
+	if err != nil {
+		return err
+	}
+	target, err := sastopo.ResolveTarget(args[1], multiPathDevices, enclosures)
+	if err != nil {
+		return err
+	}
+	if target.Enclosure == nil {
+		return fmt.Errorf("%s is not in an enclosure slot", args[1])
+	}
+
+	off, err := target.Enclosure.SlotControl(target.Slot, sastopo.SlotDeviceOff, true)
+	if err != nil {
+		return err
+	}
+	on, err := target.Enclosure.SlotControl(target.Slot, sastopo.SlotDeviceOff, false)
+	if err != nil {
+		return err
+	}
+
+	if action != "on" && target.Device != nil {
+		mounts, err := target.Device.SoleMounts()
+		if err != nil {
+			return err
+		}
+		if len(mounts) > 0 {
+			return fmt.Errorf("refusing to power off %s, it is the last remaining path of mounted filesystems: %s", target, strings.Join(mounts, ", "))
+		}
+	}
+
+	fmt.Printf("Power %s: %s\n", action, target)
+	if action != "on" {
+		fmt.Printf("    %s\n", off)
+	}
+	if action != "off" {
+		fmt.Printf("    %s\n", on)
+	}
+	if slotDryRun {
+		return nil
+	}
+	if !slotYes && !confirm("Power "+action+" this slot?") {
+		return errors.New("aborted")
+	}
+
+	if action != "on" {
+		if err := off.Run(); err != nil {
+			return err
+		}
+		if target.Device != nil {
+			if err := target.Device.WaitRemoved(slotTimeout); err != nil {
+				return err
+			}
+			fmt.Printf("Device %s removed\n", target.Device.Serial())
+		}
+	}
+	if action != "off" {
+		if err := on.Run(); err != nil {
+			return err
+		}
+		ids, err := target.Enclosure.WaitSlotDevices(target.Slot, slotTimeout)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Device returned as SCSI devices: %s\n", strings.Join(ids, ", "))
+	}
+	return nil
+}
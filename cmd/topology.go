@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	sastopo "github.com/bensallen/sastopo/lib"
+)
+
+// topologyLines describes each enclosure and multipath device with a line,
+// sorted, so topologies from before and after a change can be compared
+func topologyLines(multiPathDevices map[string]*sastopo.MultiPathDevice, enclosures map[*sastopo.Enclosure]bool) []string {
+	var lines []string
+	for enclosure := range enclosures {
+		lines = append(lines, fmt.Sprintf("Enclosure: %s, Paths: %d, Slots %d of %d populated",
+			enclosure.Name(), len(enclosure.MultiPathDevice.Paths), enclosure.PopulatedSlots(), enclosure.TotalSlots()))
+	}
+	for _, mpd := range uniqueMultiPathDevices(multiPathDevices) {
+		var blocks []string
+		for _, device := range mpd.Devices() {
+			if device.Type != 0 {
+				continue
+			}
+			blocks = append(blocks, device.Block)
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		sort.Strings(blocks)
+		line := fmt.Sprintf("Device: %s", mpd.Serial())
+		if t := mpd.Target(); t.Enclosure != nil {
+			line += fmt.Sprintf(", Enclosure: %s, Slot: %d", t.Enclosure.Name(), t.Slot)
+		}
+		lines = append(lines, line+fmt.Sprintf(", Paths: %s", strings.Join(blocks, ",")))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// uniqueMultiPathDevices returns each multipath device once, as the same
+// device may be keyed by more than one identifier
+func uniqueMultiPathDevices(multiPathDevices map[string]*sastopo.MultiPathDevice) []*sastopo.MultiPathDevice {
+	var (
+		mpds []*sastopo.MultiPathDevice
+		seen = map[*sastopo.MultiPathDevice]bool{}
+	)
+	for _, mpd := range multiPathDevices {
+		if !seen[mpd] {
+			seen[mpd] = true
+			mpds = append(mpds, mpd)
+		}
+	}
+	return mpds
+}
+
+// printTopologyDiff prints the lines removed and added between two outputs
+// of topologyLines
+func printTopologyDiff(before, after []string) {
+	was := map[string]bool{}
+	for _, line := range before {
+		was[line] = true
+	}
+	is := map[string]bool{}
+	for _, line := range after {
+		is[line] = true
+	}
+
+	changed := false
+	for _, line := range before {
+		if !is[line] {
+			fmt.Printf("- %s\n", line)
+			changed = true
+		}
+	}
+	for _, line := range after {
+		if !was[line] {
+			fmt.Printf("+ %s\n", line)
+			changed = true
+		}
+	}
+	if !changed {
+		fmt.Printf("No topology changes\n")
+	}
+}
+
+// findHBA returns the HBA with the slot label or PCI ID name
+func findHBA(name string, HBAs map[string]*sastopo.HBA) *sastopo.HBA {
+	for _, hba := range HBAs {
+		if hba.Slot == name || hba.PciID == name {
+			return hba
+		}
+	}
+	return nil
+}
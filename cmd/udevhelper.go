@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/spf13/cobra"
+
+	sastopo "github.com/bensallen/sastopo/lib"
+)
+
+// udevUnsafe matches characters not safe in udev property values and links
+var udevUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// udevHelperCmd represents the udev-helper command
+var udevHelperCmd = &cobra.Command{
+	Use:   "udev-helper <devpath>",
+	Short: "Print enclosure and slot properties of a block device for udev",
+	Long: `Look up a single block device, given as its udev $devpath, /dev/sdX or
+sdX, and print its enclosure, slot, HBA, IOM and path index as KEY=value lines
+for IMPORT{program} in a udev rule. Only the device, its enclosure and the other
+paths to the same drive are read, not the whole topology, and only from sysfs
+apart from the enclosure's SES pages. Those are read at most once a minute per
+enclosure and cached in --ses-cache, so a burst of events at boot doesn't run
+sg_ses for every drive.
+
+See examples/61-sastopo.rules for rules creating /dev/disk/by-slot links.`,
+	Args:          cobra.ExactArgs(1),
+	RunE:          udevHelper,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	RootCmd.AddCommand(udevHelperCmd)
+	udevHelperCmd.Flags().StringVar(&conf.SesCacheDir, "ses-cache", "/run/sastopo", "Directory to cache enclosure SES pages in between udev events, empty to disable")
+}
+
+func udevHelper(cmd *cobra.Command, args []string) error {
+	loadConf()
+
+	device, err := sastopo.LookupBlock(args[0], conf)
+	if err != nil {
+		return err
+	}
+
+	printUdevProperty("ID_SASTOPO_HBA", device.HBA.Name())
+	printUdevProperty("ID_SASTOPO_PATH_INDEX", fmt.Sprint(device.PathIndex()))
+	printUdevProperty("ID_SASTOPO_PATHS", fmt.Sprint(len(device.MultiPath.Paths)))
+	printUdevProperty("ID_SASTOPO_IOM", device.IOM)
+	if device.Enclosure == nil {
+		return nil
+	}
+	printUdevProperty("ID_SASTOPO_ENCLOSURE", device.Enclosure.Serial())
+	printUdevProperty("ID_SASTOPO_ENCLOSURE_LABEL", device.Enclosure.Label)
+	printUdevProperty("ID_SASTOPO_ENCLOSURE_NAME", device.Enclosure.Name())
+	if device.SlotSource != "" {
+		printUdevProperty("ID_SASTOPO_SLOT", fmt.Sprint(device.Slot))
+		printUdevProperty("ID_SASTOPO_SLOT_LABEL", device.SlotLabel)
+		printUdevProperty("ID_SASTOPO_DRAWER", device.Drawer)
+	}
+	return nil
+}
+
+// printUdevProperty prints a KEY=value line, leaving out empty values
+func printUdevProperty(key, value string) {
+	if value = udevUnsafe.ReplaceAllString(value, "_"); value != "" {
+		fmt.Printf("%s=%s\n", key, value)
+	}
+}
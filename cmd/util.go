@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	sastopo "github.com/bensallen/sastopo/lib"
+)
+
+// confirm asks the user to type "yes" to continue
+func confirm(prompt string) bool {
+	fmt.Printf("%s Type 'yes' to continue: ", prompt)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(answer) == "yes"
+}
+
+// diffLines returns the lines removed from a and added in b, prefixed with
+// "- " and "+ ", in file order, from their longest common subsequence
+func diffLines(a, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			diff = append(diff, "+ "+b[j])
+			j++
+		default:
+			diff = append(diff, "- "+a[i])
+			i++
+		}
+	}
+	return diff
+}
+
+// writeGenerated shows how content differs from file, then writes it after
+// confirmation. An empty file prints content instead.
+func writeGenerated(file, content string, dryRun, yes bool) error {
+	if file == "" {
+		fmt.Print(content)
+		return nil
+	}
+
+	existing, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	diff := diffLines(strings.Split(strings.TrimSuffix(string(existing), "\n"), "\n"), strings.Split(strings.TrimSuffix(content, "\n"), "\n"))
+	if len(existing) == 0 {
+		diff = nil
+		for _, line := range strings.Split(strings.TrimSuffix(content, "\n"), "\n") {
+			diff = append(diff, "+ "+line)
+		}
+	}
+	if len(diff) == 0 {
+		fmt.Printf("%s is up to date\n", file)
+		return nil
+	}
+	fmt.Printf("Changes to %s:\n", file)
+	for _, line := range diff {
+		fmt.Println(line)
+	}
+	if dryRun {
+		return nil
+	}
+	if !yes && !confirm(fmt.Sprintf("Write %s?", file)) {
+		return errors.New("aborted")
+	}
+	return ioutil.WriteFile(file, []byte(content), 0644)
+}
+
+// matchesAny returns true if the enclosure matches any of the ids
+func matchesAny(enclosure *sastopo.Enclosure, ids []string) bool {
+	for _, id := range ids {
+		if enclosure.Matches(id) {
+			return true
+		}
+	}
+	return false
+}
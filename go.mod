@@ -15,7 +15,7 @@ require (
 	github.com/spf13/cast v1.1.0 // indirect
 	github.com/spf13/cobra v0.0.0-20170725120438-34594c771f2c
 	github.com/spf13/jwalterweatherman v0.0.0-20170523133247-0efa5202c046 // indirect
-	github.com/spf13/pflag v1.0.0 // indirect
+	github.com/spf13/pflag v1.0.0
 	github.com/spf13/viper v1.0.0
 	golang.org/x/sys v0.0.0-20170727135323-35ef4487ce0a // indirect
 	golang.org/x/text v0.0.0-20170714085652-836efe42bb4a // indirect
@@ -0,0 +1,164 @@
+package sastopo
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bensallen/go-sysfs"
+)
+
+// Levels of a Link, from the drives up to the host
+const (
+	LinkUplink = "uplink" // SAS link into an expander from the HBA or an upstream expander
+	LinkPort   = "port"   // HBA wide port
+	LinkHBA    = "hba"    // All ports of an HBA
+	LinkPCIe   = "pcie"   // HBA's PCIe link
+)
+
+var linkLevels = map[string]int{LinkUplink: 0, LinkPort: 1, LinkHBA: 2, LinkPCIe: 3}
+
+// Link is a point in the topology that drive traffic passes through, with
+// the nominal throughput of the drives behind it and its capacity in MB/s
+type Link struct {
+	Level    string
+	Name     string
+	Lanes    int
+	Paths    int
+	Demand   float64
+	Capacity float64
+}
+
+// Ratio returns the oversubscription ratio of the link, demand over capacity,
+// or 0 if the capacity is unknown
+func (l *Link) Ratio() float64 {
+	if l.Capacity == 0 {
+		return 0
+	}
+	return l.Demand / l.Capacity
+}
+
+// sasLinkMBps returns the MB/s of a SAS negotiated link rate, ex: 12.0 Gbit.
+// SAS uses 8b/10b encoding, so each Gbit/s carries 100 MB/s.
+func sasLinkMBps(rate string) float64 {
+	fields := strings.Fields(rate)
+	if len(fields) == 0 {
+		return 0
+	}
+	gbit, _ := strconv.ParseFloat(fields[0], 64)
+	return gbit * 100
+}
+
+// pcieLaneMBps returns the MB/s of one PCIe lane at a link speed in GT/s.
+// Gen 1 and 2 use 8b/10b encoding, later generations 128b/130b.
+func pcieLaneMBps(gts float64) float64 {
+	if gts <= 5 {
+		return gts * 100
+	}
+	return gts * 1000 / 8 * 128 / 130
+}
+
+// physCapacity returns the number of lanes and MB/s of a set of phys
+func physCapacity(phys map[*Phy]bool) (int, float64) {
+	var capacity float64
+	for phy := range phys {
+		capacity += sasLinkMBps(phy.LinkRate)
+	}
+	return len(phys), capacity
+}
+
+// Bandwidth sums the nominal throughput of every disk, hdd MB/s for rotational
+// disks and ssd MB/s for the rest, at each uplink, HBA port, HBA and PCIe link
+// it passes through. A disk's throughput is split evenly between its paths.
+// Links are returned by level, then name.
+func Bandwidth(devices map[string]*Device, enclosures map[*Enclosure]bool, HBAs map[string]*HBA, hdd, ssd float64) []*Link {
+	// Name expanders after the enclosure they belong to
+	expanderEnclosure := map[string]string{}
+	for enclosure := range enclosures {
+		for device := range enclosure.MultiPathDevice.Paths {
+			if e := device.expanders(); len(e) > 0 {
+				expanderEnclosure[e[len(e)-1]] = enclosure.Name()
+			}
+		}
+	}
+
+	links := map[string]*Link{}
+	link := func(key, level, name string) (*Link, bool) {
+		if l, ok := links[key]; ok {
+			return l, false
+		}
+		links[key] = &Link{Level: level, Name: name}
+		return links[key], true
+	}
+
+	for _, hba := range HBAs {
+		h, _ := link(hba.PciID, LinkHBA, hba.String())
+		p, _ := link(hba.PciID+"/pcie", LinkPCIe, hba.String())
+		p.Lanes = hba.Link.CurrentWidth
+		p.Capacity = float64(hba.Link.CurrentWidth) * pcieLaneMBps(linkSpeed(hba.Link.CurrentSpeed))
+		for port := range hba.Ports {
+			lanes, capacity := physCapacity(port.Phys)
+			h.Lanes += lanes
+			h.Capacity += capacity
+		}
+	}
+
+	for _, device := range devices {
+		if device.Type != 0 || device.HBA == nil {
+			continue
+		}
+		demand := ssd
+		if device.Rotational {
+			demand = hdd
+		}
+		if device.MultiPath != nil && len(device.MultiPath.Paths) > 1 {
+			demand /= float64(len(device.MultiPath.Paths))
+		}
+
+		var passes []*Link
+		passes = append(passes, links[device.HBA.PciID], links[device.HBA.PciID+"/pcie"])
+
+		// Every port in the sysfs path followed by an expander is a link into
+		// that expander, the first port is the HBA's
+		p := strings.Split(string(device.sysfsObj), "/")
+		for i := 0; i < len(p)-1; i++ {
+			if !strings.HasPrefix(p[i], "port-") {
+				continue
+			}
+			key := strings.Join(p[:i+1], "/")
+			level, name := LinkUplink, p[i+1]
+			if strings.HasPrefix(p[i-1], "host") {
+				level, name = LinkPort, device.HBA.String()+" "+p[i]
+			} else if !strings.HasPrefix(p[i+1], "expander-") {
+				continue
+			} else if serial, ok := expanderEnclosure[p[i+1]]; ok {
+				name = fmt.Sprintf("%s (enclosure %s)", p[i+1], serial)
+			}
+			l, created := link(key, level, name)
+			if created {
+				l.Lanes, l.Capacity = physCapacity(portPhys(sysfs.Object(key)))
+			}
+			passes = append(passes, l)
+		}
+
+		for _, l := range passes {
+			if l != nil {
+				l.Paths++
+				l.Demand += demand
+			}
+		}
+	}
+
+	var sorted []*Link
+	for _, l := range links {
+		sorted = append(sorted, l)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Level != sorted[j].Level {
+			return linkLevels[sorted[i].Level] < linkLevels[sorted[j].Level]
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
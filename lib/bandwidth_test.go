@@ -0,0 +1,29 @@
+package sastopo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLinkCapacity(t *testing.T) {
+	phys := map[*Phy]bool{}
+	for i := 0; i < 4; i++ {
+		phys[&Phy{LinkRate: "12.0 Gbit"}] = true
+	}
+	if lanes, capacity := physCapacity(phys); lanes != 4 || capacity != 4800 {
+		t.Errorf("expected 4 lanes at 4800 MB/s, found %d at %.0f", lanes, capacity)
+	}
+
+	// PCIe gen3 x8 is ~7877 MB/s
+	if got := 8 * pcieLaneMBps(linkSpeed("8.0 GT/s PCIe")); math.Abs(got-7877) > 1 {
+		t.Errorf("unexpected PCIe gen3 x8 capacity: %.0f", got)
+	}
+	if got := pcieLaneMBps(linkSpeed("5.0 GT/s PCIe")); got != 500 {
+		t.Errorf("unexpected PCIe gen2 lane capacity: %.0f", got)
+	}
+
+	l := &Link{Demand: 9600, Capacity: 4800}
+	if l.Ratio() != 2 {
+		t.Errorf("expected 2:1 oversubscription, found %.2f", l.Ratio())
+	}
+}
@@ -0,0 +1,231 @@
+package sastopo
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Chain is the enclosures cascaded from one HBA port, nearest the HBA first
+type Chain struct {
+	HBA        *HBA
+	Port       string // HBA port ID, ex: port-2:0
+	PortNumber int    // HBA port number, lowest phy over phys per port, -1 if unknown
+	Enclosures []*Enclosure
+	positions  map[*Enclosure]int
+}
+
+// Name returns the chain's HBA slot label, or PCI ID, and port number, ex:
+// C5:0, or port ID when the port number is unknown, ex: C5:port-2:0
+func (c *Chain) Name() string {
+	return c.HBA.Name() + ":" + c.port()
+}
+
+// port returns the chain's port number, or port ID when it is unknown
+func (c *Chain) port() string {
+	if c.PortNumber < 0 {
+		return c.Port
+	}
+	return strconv.Itoa(c.PortNumber)
+}
+
+// Position returns the enclosure's position in the chain, 1 is nearest the
+// HBA, or 0 if the enclosure is not in the chain
+func (c *Chain) Position(e *Enclosure) int {
+	return c.positions[e]
+}
+
+// String describes the chain, ex: C5 port 0 → JBOD-A (1st) → JBOD-B (2nd)
+func (c *Chain) String() string {
+	s := fmt.Sprintf("%s port %s", c.HBA.Name(), c.port())
+	for _, e := range c.Enclosures {
+		s += fmt.Sprintf(" → %s (%s)", e.Name(), ordinal(c.positions[e]))
+	}
+	return s
+}
+
+// ordinal formats n as 1st, 2nd, 3rd, 4th, ...
+func ordinal(n int) string {
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return strconv.Itoa(n) + suffix
+}
+
+// enclosurePath is an enclosure's SES device path through one HBA port
+type enclosurePath struct {
+	enclosure *Enclosure
+	expanders []string
+}
+
+// Chains orders the enclosures reached through each HBA port from the
+// expanders in the sysfs paths of their SES devices. Each expander belongs to
+// the enclosure with the shortest path through it, and an enclosure is
+// downstream of every other enclosure owning an expander in its path. Chains
+// are sorted by HBA PCI ID and port number.
+func Chains(enclosures map[*Enclosure]bool) []*Chain {
+	type portKey struct {
+		hba  *HBA
+		port string
+	}
+	byPort := map[portKey][]enclosurePath{}
+	for enclosure := range enclosures {
+		for device := range enclosure.MultiPathDevice.Paths {
+			expanders := device.expanders()
+			if device.HBA == nil || len(expanders) == 0 {
+				continue
+			}
+			key := portKey{device.HBA, device.Port}
+			byPort[key] = append(byPort[key], enclosurePath{enclosure, expanders})
+		}
+	}
+
+	var chains []*Chain
+	for key, paths := range byPort {
+		c := &Chain{HBA: key.hba, Port: key.port, PortNumber: chainPortNumber(key.hba, key.port), positions: map[*Enclosure]int{}}
+
+		// Shortest paths first, so the first owner found for an expander is
+		// the nearest enclosure
+		sort.Slice(paths, func(i, j int) bool {
+			if len(paths[i].expanders) != len(paths[j].expanders) {
+				return len(paths[i].expanders) < len(paths[j].expanders)
+			}
+			return paths[i].enclosure.Name() < paths[j].enclosure.Name()
+		})
+		owners := map[string]*Enclosure{}
+		for _, p := range paths {
+			for _, expander := range p.expanders {
+				if owners[expander] == nil {
+					owners[expander] = p.enclosure
+				}
+			}
+		}
+
+		for _, p := range paths {
+			if c.positions[p.enclosure] != 0 {
+				continue
+			}
+			upstream := map[*Enclosure]bool{}
+			for _, expander := range p.expanders {
+				if owners[expander] != p.enclosure {
+					upstream[owners[expander]] = true
+				}
+			}
+			c.positions[p.enclosure] = len(upstream) + 1
+			c.Enclosures = append(c.Enclosures, p.enclosure)
+		}
+		sort.Slice(c.Enclosures, func(i, j int) bool {
+			pi, pj := c.positions[c.Enclosures[i]], c.positions[c.Enclosures[j]]
+			if pi != pj {
+				return pi < pj
+			}
+			return c.Enclosures[i].Name() < c.Enclosures[j].Name()
+		})
+		chains = append(chains, c)
+	}
+	sort.Slice(chains, func(i, j int) bool {
+		if chains[i].HBA.PciID != chains[j].HBA.PciID {
+			return chains[i].HBA.PciID < chains[j].HBA.PciID
+		}
+		// Ports with an unknown number last, by port ID
+		if (chains[i].PortNumber < 0) != (chains[j].PortNumber < 0) {
+			return chains[j].PortNumber < 0
+		}
+		if chains[i].PortNumber != chains[j].PortNumber {
+			return chains[i].PortNumber < chains[j].PortNumber
+		}
+		return chains[i].Port < chains[j].Port
+	})
+	return chains
+}
+
+// chainPortNumber returns the number of the HBA port, or -1 with a
+// diagnostic when the port has no numbered phys to work it out from
+func chainPortNumber(hba *HBA, portID string) int {
+	if port := hba.Port(portID); port != nil {
+		for phy := range port.Phys {
+			if _, err := strconv.Atoi(phy.PhyIdentifier); err == nil {
+				n, _ := portNumber(hba, portID)
+				return n
+			}
+		}
+	}
+	diagnostic("HBA %s %s has no numbered phys, its chain is named by port ID", hba.Name(), portID)
+	return -1
+}
+
+// matches returns true if id names the chain as <HBA>:<port>, where the
+// HBA is its slot label or PCI ID and the port its number, or port ID when
+// the number is unknown
+func (c *Chain) matches(id string) bool {
+	hba := strings.TrimSuffix(id, ":"+c.port())
+	if hba == id {
+		return false
+	}
+	return hba == c.HBA.PciID || (c.HBA.Slot != "" && hba == c.HBA.Slot)
+}
+
+// CheckCabling compares the chains with a cabling spec and with the chain
+// positions configured for each enclosure. The spec lists the enclosures
+// expected on each chain, nearest the HBA first, keyed by <HBA>:<port> where
+// the HBA is its slot label or PCI ID, ex: C5:0. Enclosures are given by
+// serial, label or logical ID. Every difference is returned.
+func CheckCabling(chains []*Chain, enclosures map[*Enclosure]bool, spec map[string][]string) []string {
+	var problems []string
+
+	var keys []string
+	for key := range spec {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var chain *Chain
+		for _, c := range chains {
+			if c.matches(key) {
+				chain = c
+			}
+		}
+		if chain == nil {
+			problems = append(problems, fmt.Sprintf("chain %s: no enclosures found, expected %s", key, strings.Join(spec[key], ", ")))
+			continue
+		}
+
+		var found []string
+		for _, e := range chain.Enclosures {
+			found = append(found, e.Name())
+		}
+		ok := len(chain.Enclosures) == len(spec[key])
+		for i := 0; ok && i < len(spec[key]); i++ {
+			ok = chain.Enclosures[i].Matches(spec[key][i]) && chain.Position(chain.Enclosures[i]) == i+1
+		}
+		if !ok {
+			problems = append(problems, fmt.Sprintf("chain %s: expected %s, found %s", key, strings.Join(spec[key], ", "), strings.Join(found, ", ")))
+		}
+	}
+
+	// Enclosures configured with a chain position must be at it on every
+	// chain they are reached through
+	var sorted []*Enclosure
+	for enclosure := range enclosures {
+		if enclosure.Chain != 0 {
+			sorted = append(sorted, enclosure)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name() < sorted[j].Name() })
+	for _, enclosure := range sorted {
+		for _, c := range chains {
+			if p := c.Position(enclosure); p != 0 && p != enclosure.Chain {
+				problems = append(problems, fmt.Sprintf("enclosure %s: %s in chain %s, expected %s", enclosure.Name(), ordinal(p), c.Name(), ordinal(enclosure.Chain)))
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,90 @@
+package sastopo
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bensallen/go-sysfs"
+)
+
+func TestChains(t *testing.T) {
+	hba := &HBA{PciID: "0000:90:00.0", Host: "host2", Slot: "C5", Ports: map[*HBAPort]bool{
+		{PortID: "port-2:0", Phys: map[*Phy]bool{{PhyIdentifier: "0"}: true, {PhyIdentifier: "1"}: true, {PhyIdentifier: "2"}: true, {PhyIdentifier: "3"}: true}}: true,
+	}}
+	host := "/sys/devices/pci0000:80/0000:80:03.0/0000:90:00.0/host2/port-2:0"
+	enclosure := func(serial, label, path string) *Enclosure {
+		return &Enclosure{Label: label, MultiPathDevice: &MultiPathDevice{Paths: map[*Device]bool{
+			{Serial: serial, Type: 13, HBA: hba, Port: "port-2:0", sysfsObj: sysfs.Object(host + path + "/end_device-2:0:1/target2:0:1/2:0:1:0")}: true,
+		}}}
+	}
+	// JBOD-B hangs off JBOD-A's expansion port and JBOD-C off JBOD-B's, both
+	// with a second level of expanders inside them
+	a := enclosure("SNA", "JBOD-A", "/expander-2:0/port-2:0:0")
+	b := enclosure("SNB", "JBOD-B", "/expander-2:0/port-2:0:12/expander-2:1/port-2:1:0/expander-2:2/port-2:2:0")
+	c := enclosure("SNC", "JBOD-C", "/expander-2:0/port-2:0:12/expander-2:1/port-2:1:12/expander-2:3/port-2:3:0/expander-2:4/port-2:4:0")
+	enclosures := map[*Enclosure]bool{a: true, b: true, c: true}
+
+	chains := Chains(enclosures)
+	if len(chains) != 1 {
+		t.Fatalf("expected 1 chain, found %d", len(chains))
+	}
+	want := "C5 port 0 → JBOD-A (1st) → JBOD-B (2nd) → JBOD-C (3rd)"
+	if got := chains[0].String(); got != want {
+		t.Errorf("chain = %q, want %q", got, want)
+	}
+
+	if problems := CheckCabling(chains, enclosures, map[string][]string{"C5:0": {"JBOD-A", "SNB", "JBOD-C"}}); len(problems) != 0 {
+		t.Errorf("unexpected cabling problems: %v", problems)
+	}
+	problems := CheckCabling(chains, enclosures, map[string][]string{"C5:0": {"JBOD-B", "JBOD-A", "JBOD-C"}, "C6:0": {"JBOD-D"}})
+	if len(problems) != 2 || !strings.Contains(problems[0], "found JBOD-A, JBOD-B, JBOD-C") {
+		t.Errorf("unexpected cabling problems: %v", problems)
+	}
+
+	c.Chain = 2
+	if problems := CheckCabling(chains, enclosures, nil); len(problems) != 1 {
+		t.Errorf("expected a problem for JBOD-C's configured position, found %v", problems)
+	}
+}
+
+func TestChainsPortOrder(t *testing.T) {
+	phys := func(ids ...string) map[*Phy]bool {
+		m := map[*Phy]bool{}
+		for _, id := range ids {
+			m[&Phy{PhyIdentifier: id}] = true
+		}
+		return m
+	}
+	hba := &HBA{PciID: "0000:90:00.0", Host: "host3", Ports: map[*HBAPort]bool{
+		{PortID: "port-3:10", Phys: phys("4", "5", "6", "7")}: true,
+		{PortID: "port-3:2", Phys: phys("0", "1", "2", "3")}:  true,
+	}}
+	enclosures := map[*Enclosure]bool{}
+	// port-3:20 is not among the HBA's ports, so its number is unknown
+	for _, port := range []string{"port-3:10", "port-3:20", "port-3:2"} {
+		path := "/sys/devices/pci0000:80/0000:80:03.0/0000:90:00.0/host3/" + port + "/expander-3:0/port-3:0:0/end_device-3:0:1/target3:0:1/3:0:1:0"
+		enclosures[&Enclosure{MultiPathDevice: &MultiPathDevice{Paths: map[*Device]bool{
+			{Serial: "SN" + port, Type: 13, HBA: hba, Port: port, sysfsObj: sysfs.Object(path)}: true,
+		}}}] = true
+	}
+
+	resetDiagnostics()
+	chains := Chains(enclosures)
+	if len(chains) != 3 || chains[0].Name() != "0000:90:00.0:0" || chains[1].Name() != "0000:90:00.0:1" || chains[2].Name() != "0000:90:00.0:port-3:20" {
+		t.Fatalf("chains not in port number order: %v", chains)
+	}
+	if len(Diagnostics()) != 1 {
+		t.Errorf("expected a diagnostic for the unknown port number, found %v", Diagnostics())
+	}
+	if !chains[2].matches("0000:90:00.0:port-3:20") || chains[2].matches("0000:90:00.0:0") {
+		t.Errorf("unexpected matches for a chain named by port ID")
+	}
+}
+
+func TestOrdinal(t *testing.T) {
+	for n, want := range map[int]string{1: "1st", 2: "2nd", 3: "3rd", 4: "4th", 11: "11th", 12: "12th", 21: "21st", 112: "112th"} {
+		if got := ordinal(n); got != want {
+			t.Errorf("ordinal(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
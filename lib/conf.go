@@ -3,9 +3,28 @@ package sastopo
 // Conf is a struct used for parsing the yaml configure file
 type Conf struct {
 	Mismatch           bool
+	Reconcile          bool
 	PathCount          int
 	SysfsMatchPathEncl int
 	Summary            bool
+	SesCacheDir        string                       // Where udev-helper caches SES pages, empty to disable
+	HDDThroughput      float64                      `yaml:"HDDThroughput"`
+	SSDThroughput      float64                      `yaml:"SSDThroughput"`
+	AERStateFile       string                       `yaml:"AERStateFile"`
+	NumaNode           int                          `yaml:"NumaNode"`
+	MinHBAFirmware     string                       `yaml:"MinHBAFirmware"`
 	HBALabels          map[string]string            `yaml:"HBALabels"`
 	EnclLabels         map[string]map[string]string `yaml:"EnclLabels"`
+	Enclosures         map[string]EnclosureConf     `yaml:"Enclosures"`
+	Cabling            map[string][]string          `yaml:"Cabling"`
+	Layouts            map[string]Layout            `yaml:"Layouts"`
+}
+
+// EnclosureConf labels an enclosure, keyed by serial or SES logical ID, and
+// places it in a rack and SAS chain
+type EnclosureConf struct {
+	Label string `yaml:"Label"`
+	Rack  string `yaml:"Rack"`
+	U     int    `yaml:"U"`     // Lowest rack unit the enclosure occupies
+	Chain int    `yaml:"Chain"` // Position in its daisy chain, 1 is nearest the HBA
 }
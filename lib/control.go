@@ -0,0 +1,256 @@
+package sastopo
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bensallen/go-sysfs"
+)
+
+// sg_ses acronyms for device slot control fields
+const (
+	SlotDeviceOff = "devoff"
+	SlotIdent     = "ident"
+)
+
+// pollInterval is how often sysfs and SES are checked while waiting for a device
+var pollInterval = 2 * time.Second
+
+// SlotControl sets or clears a control field of an enclosure's device slot
+// element with sg_ses
+type SlotControl struct {
+	Enclosure *Enclosure
+	Element   *SesElement
+	Field     string // sg_ses acronym, ex: devoff or ident
+	Set       bool
+}
+
+// SlotControl returns a SlotControl for the SES device slot element of slot
+func (e *Enclosure) SlotControl(slot int, field string, set bool) (*SlotControl, error) {
+	el := e.SlotElement(slot)
+	if el == nil {
+		return nil, fmt.Errorf("no SES device slot element for slot %d in enclosure %s", slot, e.Serial())
+	}
+	if e.sg() == "" {
+		return nil, fmt.Errorf("no SCSI generic device found for enclosure %s", e.Serial())
+	}
+	return &SlotControl{Enclosure: e, Element: el, Field: field, Set: set}, nil
+}
+
+// Args returns the sg_ses arguments. The element is addressed by its type
+// descriptor header and position within it.
+func (c *SlotControl) Args() []string {
+	action := "--clear="
+	if c.Set {
+		action = "--set="
+	}
+	return []string{
+		fmt.Sprintf("--index=%d,%d", c.Element.TypeHeader, c.Element.TypeIndex),
+		action + c.Field,
+		"/dev/" + c.Enclosure.sg(),
+	}
+}
+
+func (c *SlotControl) String() string {
+	return "sg_ses " + strings.Join(c.Args(), " ")
+}
+
+// Run runs sg_ses to change the control field.
+// This function requires root privledges and sg3_utils to be installed.
+func (c *SlotControl) Run() error {
+	if out, err := exec.Command("sg_ses", c.Args()...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %s: %s", c, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// WaitRemoved polls sysfs until every path of the multipath device is gone
+func (mpd *MultiPathDevice) WaitRemoved(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		remaining := 0
+		for device := range mpd.Paths {
+			if _, err := os.Stat(string(device.sysfsObj)); err == nil {
+				remaining++
+			}
+		}
+		if remaining == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s: %d of %d paths of %s still present", ErrTimeout, remaining, len(mpd.Paths), mpd.Serial())
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// WaitSlotDevices polls SES and sysfs until a SCSI device attached to the
+// slot appears, and returns the SCSI IDs of its paths
+func (e *Enclosure) WaitSlotDevices(slot int, timeout time.Duration) ([]string, error) {
+	el := e.SlotElement(slot)
+	if el == nil {
+		return nil, fmt.Errorf("no SES device slot element for slot %d in enclosure %s", slot, e.Serial())
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		if page, err := sgSesPage(e.sg(), sesPageAdditional); err == nil {
+			parseSesAdditional(page, e.Elements)
+		}
+		if ids := scsiDeviceIDs(el.SasAddresses); len(ids) > 0 {
+			return ids, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s: no device appeared in slot %d of enclosure %s", ErrTimeout, slot, e.Serial())
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// scsiDeviceIDs returns the IDs of the SCSI devices in sysfs with one of the
+// SAS addresses
+func scsiDeviceIDs(sasAddresses []string) []string {
+	var ids []string
+	if len(sasAddresses) == 0 {
+		return ids
+	}
+	for _, obj := range sysfs.Class.Object("scsi_device").SubObjects() {
+		addr, err := obj.Attribute("device/sas_address").Read()
+		if err != nil {
+			continue
+		}
+		for _, a := range sasAddresses {
+			if a == addr {
+				ids = append(ids, obj.Name())
+			}
+		}
+	}
+	return ids
+}
+
+// Delete removes the SCSI device from the kernel by writing to its sysfs
+// delete attribute
+func (d *Device) Delete() error {
+	return d.sysfsObj.Attribute("delete").Write("1")
+}
+
+// dmDeleteOrder ranks device-mapper path states by when a path is deleted,
+// paths not in use first and paths in the active group, or not in a map, last
+var dmDeleteOrder = map[string]int{
+	DMPathFailed:   0,
+	DMPathDisabled: 1,
+	DMPathEnabled:  2,
+}
+
+// Delete removes every path of the multipath device from the kernel, failed
+// and standby paths before active ones, then in order of SCSI ID
+func (mpd *MultiPathDevice) Delete() error {
+	devices := mpd.Devices()
+	sort.Slice(devices, func(i, j int) bool {
+		oi, ok := dmDeleteOrder[devices[i].DMState]
+		if !ok {
+			oi = len(dmDeleteOrder)
+		}
+		oj, ok := dmDeleteOrder[devices[j].DMState]
+		if !ok {
+			oj = len(dmDeleteOrder)
+		}
+		if oi != oj {
+			return oi < oj
+		}
+		return scsiIDLess(devices[i].ID, devices[j].ID)
+	})
+	for _, device := range devices {
+		if err := device.Delete(); err != nil {
+			return fmt.Errorf("deleting %s (%s) failed: %s", device.ID, device.Block, err)
+		}
+	}
+	return nil
+}
+
+// Scan asks the kernel to scan every channel, target and LUN of the HBA's
+// SCSI host for new devices
+func (h *HBA) Scan() error {
+	return sysfs.Class.Object("scsi_host/" + h.Host).Attribute("scan").Write("- - -")
+}
+
+// Holders returns the block devices, such as device-mapper maps, that are
+// built on any path of the multipath device
+func (mpd *MultiPathDevice) Holders() []string {
+	var (
+		holders []string
+		seen    = map[string]bool{}
+	)
+	for device := range mpd.Paths {
+		if device.Block == "" {
+			continue
+		}
+		dev, err := sysfs.Class.Object("block").SubObject(device.Block + "/holders")
+		if err != nil {
+			continue
+		}
+		for _, holder := range dev.SubObjects() {
+			if !seen[holder.Name()] {
+				seen[holder.Name()] = true
+				holders = append(holders, holder.Name())
+			}
+		}
+	}
+	sort.Strings(holders)
+	return holders
+}
+
+// MultipathMaps returns the names of the device-mapper multipath maps built on
+// the multipath device's paths, or an error if any other holder, such as an
+// md array, is built on them
+func (mpd *MultiPathDevice) MultipathMaps() ([]string, error) {
+	var maps []string
+	for _, holder := range mpd.Holders() {
+		dm, err := sysfs.Class.Object("block").SubObject(holder + "/dm")
+		if err != nil {
+			return nil, fmt.Errorf("%s is still in use by %s, remove it first", mpd.Serial(), holder)
+		}
+		uuid, _ := dm.Attribute("uuid").Read()
+		if !strings.HasPrefix(strings.TrimSpace(uuid), "mpath-") {
+			return nil, fmt.Errorf("%s is still in use by %s, remove it first", mpd.Serial(), holder)
+		}
+		name, err := dm.Attribute("name").Read()
+		if err != nil {
+			return nil, fmt.Errorf("reading the name of %s failed: %s", holder, err)
+		}
+		maps = append(maps, strings.TrimSpace(name))
+	}
+	return maps, nil
+}
+
+// FlushMultipath removes a device-mapper multipath map with multipath -f.
+// This function requires root privledges and multipath-tools to be installed.
+func FlushMultipath(name string) error {
+	if out, err := exec.Command("multipath", "-f", name).CombinedOutput(); err != nil {
+		return fmt.Errorf("multipath -f %s failed: %s: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// scsiIDLess returns true if SCSI ID a (H:C:T:L) sorts before b, comparing
+// each field as a number
+func scsiIDLess(a, b string) bool {
+	as, bs := strings.Split(a, ":"), strings.Split(b, ":")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, errx := strconv.Atoi(as[i])
+		y, erry := strconv.Atoi(bs[i])
+		if errx != nil || erry != nil {
+			if as[i] != bs[i] {
+				return as[i] < bs[i]
+			}
+		} else if x != y {
+			return x < y
+		}
+	}
+	return len(as) < len(bs)
+}
@@ -0,0 +1,17 @@
+package sastopo
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestScsiIDLess(t *testing.T) {
+	ids := []string{"10:0:2:0", "9:0:10:0", "9:0:2:0", "1:0:0:1"}
+	sort.Slice(ids, func(i, j int) bool { return scsiIDLess(ids[i], ids[j]) })
+	want := []string{"1:0:0:1", "9:0:2:0", "9:0:10:0", "10:0:2:0"}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("sorted %v, want %v", ids, want)
+		}
+	}
+}
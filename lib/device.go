@@ -2,7 +2,6 @@ package sastopo
 
 import (
 	"errors"
-	"log"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -17,6 +16,8 @@ type Device struct {
 	Vendor     string
 	Model      string
 	Rev        string
+	Rotational bool
+	Size       int64 // Block device size in bytes
 	SasAddress string
 	Serial     string
 	Block      string
@@ -25,13 +26,23 @@ type Device struct {
 	HBA        *HBA
 	Port       string
 	Slot       int
+	SlotLabel  string
+	SlotSource string // Where Slot was found: bay_identifier, enclosure_device or ses
+	Drawer     string // Drawer of the slot in a high density enclosure, ex: Drawer 2
+	State      string // SCSI device state, ex: running, offline, blocked
+	DMState    string // Path state in the device-mapper multipath map: active, enabled, disabled or failed
+	DMGroup    int    // Priority group of the path in the device-mapper multipath map, from 1
+	Expander   string // SAS address of the enclosure expander the path goes through
+	IOM        string // Enclosure IOM or ESM the path goes through, from SES
 	MultiPath  *MultiPathDevice
 	sysfsObj   sysfs.Object
+	component  string // Enclosure component named by the enclosure_device link
 }
 
 // MultiPathDevice contains Devices which has multiple paths
 type MultiPathDevice struct {
 	Paths map[*Device]bool
+	DM    *DMDevice // Device-mapper multipath map built on the paths, if any
 }
 
 // Devices converts the MultiPathDevice Paths map to a slice of *Devices
@@ -59,6 +70,22 @@ func (mpd *MultiPathDevice) Model() string {
 	return ""
 }
 
+// SlotLabel returns the first slot label attribute from a MultiPathDevice
+func (mpd *MultiPathDevice) SlotLabel() string {
+	for device := range mpd.Paths {
+		return device.SlotLabel
+	}
+	return ""
+}
+
+// Rev returns the first firmware revision attribute from a MultiPathDevice
+func (mpd *MultiPathDevice) Rev() string {
+	for device := range mpd.Paths {
+		return device.Rev
+	}
+	return ""
+}
+
 // Vendor returns the first vendor attribute from a MultiPathDevice
 func (mpd *MultiPathDevice) Vendor() string {
 	for device := range mpd.Paths {
@@ -91,7 +118,7 @@ func (d *Device) updateSysfsAttrs() error {
 	sasAddress, err := d.sysfsObj.Attribute("sas_address").Read()
 	// Some devices won't have a sas_address in sysfs, so just warn on it
 	if err != nil {
-		log.Printf("Warning, cannot find sas_address: %s", err)
+		warning("cannot find sas_address: %s", err)
 	}
 	d.SasAddress = sasAddress
 
@@ -101,9 +128,19 @@ func (d *Device) updateSysfsAttrs() error {
 	}
 	d.Type = devType
 
+	// Not fatal, only reported
+	d.State, _ = d.sysfsObj.Attribute("state").Read()
+
 	block, err := d.sysfsObj.SubObject("block")
 	if err == nil {
 		d.Block = block.SubObjects()[0].Name()
+		// Assume a spinning disk unless the kernel says otherwise
+		rotational, err := block.SubObjects()[0].Attribute("queue/rotational").Read()
+		d.Rotational = err != nil || rotational != "0"
+		// size is in 512 byte sectors whatever the logical block size
+		if sectors, err := block.SubObjects()[0].Attribute("size").ReadInt(); err == nil {
+			d.Size = int64(sectors) * 512
+		}
 	}
 
 	sg, err := d.sysfsObj.SubObject("scsi_generic")
@@ -139,51 +176,65 @@ func (d *Device) updateSerial() error {
 	return nil
 }
 
-func findHBAPorts(host sysfs.Object) map[*HBAPort]bool {
+// portPhys returns the Phys of a SAS port sysfs object
+func portPhys(port sysfs.Object) map[*Phy]bool {
+	var phys = map[*Phy]bool{}
 
-	var HBAPorts = map[*HBAPort]bool{}
-	//fmt.Printf("findHBAPorts SubObjectsFilter: %v\n", host.SubObjectsFilter("port-*"))
-	for _, port := range host.SubObjectsFilter("port-*") {
-		//fmt.Printf("findHBAPorts port: %v\n", port)
+	for _, phy := range port.SubObjectsFilter("phy-*") {
+		//fmt.Printf("findHBAPorts phy: %v\n", phy)
 
-		var phys = map[*Phy]bool{}
+		sasPhy, err := phy.SubObject("sas_phy")
 
-		for _, phy := range port.SubObjectsFilter("phy-*") {
-			//fmt.Printf("findHBAPorts phy: %v\n", phy)
+		if err != nil {
+			continue
+		}
 
-			sasPhy, err := phy.SubObject("sas_phy")
+		sasPhy, err = sasPhy.SubObject(phy.Name())
 
-			if err != nil {
-				continue
-			}
+		if err != nil {
+			continue
+		}
 
-			sasPhy, err = sasPhy.SubObject(phy.Name())
+		phyIdentifier, err := sasPhy.Attribute("phy_identifier").Read()
+		//fmt.Printf("findHBAPorts phyIdentifier: %v\n", phyIdentifier)
 
-			if err != nil {
-				continue
-			}
+		if err != nil {
+			continue
+		}
+		sasAddress, err := sasPhy.Attribute("sas_address").Read()
+		//fmt.Printf("findHBAPorts sasAddress: %v\n", sasAddress)
+		if err != nil {
+			continue
+		}
+		// Not fatal, some drivers don't report a link rate or error counters
+		linkRate, _ := sasPhy.Attribute("negotiated_linkrate").Read()
+		var errs PhyErrors
+		errs.InvalidDword, _ = sasPhy.Attribute("invalid_dword_count").ReadInt()
+		errs.RunningDisparity, _ = sasPhy.Attribute("running_disparity_error_count").ReadInt()
+		errs.LossOfDwordSync, _ = sasPhy.Attribute("loss_of_dword_sync_count").ReadInt()
+		errs.PhyResetProblem, _ = sasPhy.Attribute("phy_reset_problem_count").ReadInt()
+
+		phys[&Phy{
+			PhyIdentifier: phyIdentifier,
+			SasAddress:    sasAddress,
+			LinkRate:      linkRate,
+			Errors:        errs,
+		}] = true
 
-			phyIdentifier, err := sasPhy.Attribute("phy_identifier").Read()
-			//fmt.Printf("findHBAPorts phyIdentifier: %v\n", phyIdentifier)
+	}
+	return phys
+}
 
-			if err != nil {
-				continue
-			}
-			sasAddress, err := sasPhy.Attribute("sas_address").Read()
-			//fmt.Printf("findHBAPorts sasAddress: %v\n", sasAddress)
-			if err != nil {
-				continue
-			}
+func findHBAPorts(host sysfs.Object) map[*HBAPort]bool {
 
-			phys[&Phy{
-				PhyIdentifier: phyIdentifier,
-				SasAddress:    sasAddress,
-			}] = true
+	var HBAPorts = map[*HBAPort]bool{}
+	//fmt.Printf("findHBAPorts SubObjectsFilter: %v\n", host.SubObjectsFilter("port-*"))
+	for _, port := range host.SubObjectsFilter("port-*") {
+		//fmt.Printf("findHBAPorts port: %v\n", port)
 
-		}
 		HBAPorts[&HBAPort{
 			PortID: port.Name(),
-			Phys:   phys,
+			Phys:   portPhys(port),
 		}] = true
 
 	}
@@ -205,11 +256,17 @@ func (d *Device) updatePathVars(HBAs map[string]*HBA, conf Conf) error {
 		//fmt.Printf("updatePathVars host: %v\n", host)
 
 		HBAs[p[5]] = &HBA{
-			PciID: p[5],
-			Host:  p[6],
-			Slot:  conf.HBALabels[p[5]],
-			Ports: findHBAPorts(host),
+			PciID:    p[5],
+			Host:     p[6],
+			Slot:     conf.HBALabels[p[5]],
+			Ports:    findHBAPorts(host),
+			sysfsObj: d.sysfsObj.Parent(-6),
+		}
+		if HBAs[p[5]].Slot != "" {
+			HBAs[p[5]].SlotSource = "config"
 		}
+		HBAs[p[5]].updateInventory()
+		HBAs[p[5]].updateLink()
 		d.HBA = HBAs[p[5]]
 	}
 	d.Port = p[7]
@@ -217,6 +274,18 @@ func (d *Device) updatePathVars(HBAs map[string]*HBA, conf Conf) error {
 	return nil
 }
 
+// expanders returns the names of the expanders in the device's sysfs path,
+// nearest the HBA first
+func (d *Device) expanders() []string {
+	var names []string
+	for _, p := range strings.Split(string(d.sysfsObj), "/") {
+		if strings.HasPrefix(p, "expander-") {
+			names = append(names, p)
+		}
+	}
+	return names
+}
+
 // updateEnclSlot updates Slot from sysfs, ex: <device>/enclosure_device:Slot 1 or
 // contents of parent end_device's bay_identifier
 func (d *Device) updateEnclSlot() error {
@@ -234,26 +303,32 @@ func (d *Device) updateEnclSlot() error {
 	slot, err2 := sasDevice.Attribute("bay_identifier").ReadInt()
 	//fmt.Printf("Vendor: %s, Model: %s, endDevice: %s, sasDevice: %s, slot %d\n", d.Vendor, d.Model, endDevice, sasDevice, slot)
 
-	if err1 == nil && err2 == nil {
-		d.Slot = slot
-	} else {
-
-		// Older sysfs implmentations exposed slots directly in the target as a symlink named
-		// "enclosure_device:<slot name>". Try to parse slot out of that path.
-		files, err := filepath.Glob(string(d.sysfsObj) + "/enclosure_device:*")
-
-		if err != nil || len(files) == 0 {
-			return err
-		} else if len(files) > 1 {
-			log.Printf("Warning: found more than one enclosure_device for dev: %s, using the first one", d.ID)
+	// The target has a symlink named "enclosure_device:<component>" to the
+	// enclosure component, and so the SES element, the device is in
+	files, err := filepath.Glob(string(d.sysfsObj) + "/enclosure_device:*")
+	if err != nil {
+		return err
+	} else if len(files) > 1 {
+		warning("found more than one enclosure_device for dev: %s, using the first one", d.ID)
+	}
+	if len(files) > 0 {
+		path := strings.Split(files[0], "/")
+		if enclSlot := strings.SplitN(path[len(path)-1], ":", 2); len(enclSlot) == 2 {
+			d.component = strings.TrimSpace(enclSlot[1])
 		}
+	}
 
-		path := strings.Split(files[0], "/")
-		enclSlot := strings.Split(path[len(path)-1], ":")
-		if len(enclSlot) == 2 {
-			d.Slot, err = strconv.Atoi(strings.TrimSpace(enclSlot[1]))
+	if err1 == nil && err2 == nil {
+		d.Slot = slot
+		d.SlotSource = "bay_identifier"
+	} else if d.component != "" {
+		// Older sysfs implmentations exposed slots only through the
+		// enclosure_device symlink. Try to parse slot out of its name.
+		d.SlotLabel = d.component
+		if d.Slot, err = strconv.Atoi(d.component); err != nil {
 			return err
 		}
+		d.SlotSource = "enclosure_device"
 	}
 	return nil
 }
@@ -283,7 +358,7 @@ func updateMultiPaths(devices map[string]*Device, devicesBySerial map[string]map
 			uniqDevice = devicesBySerial[device.SasAddress]
 			id = device.SasAddress
 		} else {
-			log.Printf("Warning: Did not find device: %s, in devicesBySerial or devicesBySASAddress", device.ID)
+			warning("Did not find device: %s, in devicesBySerial or devicesBySASAddress", device.ID)
 			continue
 		}
 
@@ -317,15 +392,25 @@ func updateEnclosure(devices map[string]*Device, enclosures map[*Enclosure]bool,
 	for _, device := range devices {
 		path := strings.Split(string(device.sysfsObj), "/")
 		device.Enclosure = enclosuresBySysfsPrefix[strings.Join(path[0:n], "/")]
-		if device.Enclosure != nil {
-			if device.Enclosure.Slots == nil {
-				device.Enclosure.Slots = map[int]*MultiPathDevice{}
-			}
-			// Only assign disks (type 0) to slots
-			if device.Type == 0 {
-				device.Enclosure.Slots[device.Slot] = device.MultiPath
-			}
+	}
+}
+
+// updateSlots (re)builds each enclosure's Slots from the Enclosure and Slot
+// of its devices. Disks without a known slot are reported and left out.
+func updateSlots(devices map[string]*Device, enclosures map[*Enclosure]bool) {
+	for enclosure := range enclosures {
+		enclosure.Slots = map[int]*MultiPathDevice{}
+	}
+	for _, device := range devices {
+		// Only assign disks (type 0) to slots
+		if device.Enclosure == nil || device.Type != 0 {
+			continue
 		}
+		if device.SlotSource == "" {
+			diagnostic("%s (%s): no slot found in sysfs or SES for enclosure %s", device.ID, device.Serial, device.Enclosure.Serial())
+			continue
+		}
+		device.Enclosure.Slots[device.Slot] = device.MultiPath
 	}
 }
 
@@ -335,6 +420,8 @@ func updateEnclosure(devices map[string]*Device, enclosures map[*Enclosure]bool,
 // and enclosure sysfs path to match against to assign a device
 // to an enclosure
 func ScsiDevices(conf Conf) (map[string]*Device, map[string]*MultiPathDevice, map[*Enclosure]bool, map[string]*HBA, error) {
+	resetDiagnostics()
+
 	var (
 		Devices             = map[string]*Device{}
 		DevicesBySerial     = map[string]map[*Device]bool{}
@@ -350,7 +437,7 @@ func ScsiDevices(conf Conf) (map[string]*Device, map[string]*MultiPathDevice, ma
 		name := sysfsObjects[d].Name()
 		sysfsObj, err := sysfsObjects[d].SubObject("device")
 		if err != nil {
-			log.Printf("Warning, %s, skipping device %s", err, name)
+			warning("%s, skipping device %s", err, name)
 			continue
 		}
 		Devices[name] = &Device{
@@ -359,24 +446,24 @@ func ScsiDevices(conf Conf) (map[string]*Device, map[string]*MultiPathDevice, ma
 			Type:     -1,
 		}
 		if err := Devices[name].updateSysfsAttrs(); err != nil {
-			log.Printf("Warning: %s", err)
+			warning("%s", err)
 		}
 		if err := Devices[name].updateSerial(); err != nil {
 			if err == ErrUnknownType {
 				delete(Devices, name)
-				log.Printf("Warning, %s, skipping device %s", err, name)
+				warning("%s, skipping device %s", err, name)
 				continue
 			} else if err != nil {
-				log.Printf("Warning: %s", err)
+				warning("%s", err)
 			}
 		}
 		if err := Devices[name].updatePathVars(HBAs, conf); err != nil {
-			log.Printf("Warning: %s", err)
+			warning("%s", err)
 		}
 
 		if Devices[name].Type == 0 {
 			if err := Devices[name].updateEnclSlot(); err != nil {
-				log.Printf("Warning: %s", err)
+				warning("%s", err)
 			}
 		}
 
@@ -401,10 +488,18 @@ func ScsiDevices(conf Conf) (map[string]*Device, map[string]*MultiPathDevice, ma
 			EnclMap[Devices[name]] = true
 		}
 	}
+	updateHBASlots(HBAs)
+
 	// Assign MultiPathDevice to Devices, get back map of all MultiPath Devices
 	multiPathDevices := updateMultiPaths(Devices, DevicesBySerial, DevicesBySASAddress)
+	updateDM(multiPathDevices)
 	enclosures := Enclosures(EnclMap)
 	updateEnclosure(Devices, enclosures, conf.SysfsMatchPathEncl)
+	updateEnclosureSes(enclosures, Devices, DevicesBySASAddress)
+	updateEnclosureLabels(enclosures, conf)
+	updateSlots(Devices, enclosures)
+	updateSlotLabels(enclosures)
+	updateIOMs(Devices)
 
 	return Devices, multiPathDevices, enclosures, HBAs, nil
 
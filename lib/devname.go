@@ -0,0 +1,74 @@
+package sastopo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// byIDPrefixes are the /dev/disk/by-id link prefixes in order of preference
+var byIDPrefixes = []string{"dm-uuid-mpath-", "wwn-", "scsi-"}
+
+// byIDLinks returns the /dev/disk/by-id links keyed by the block device they
+// point to, ex: sda
+func byIDLinks() map[string][]string {
+	links := map[string][]string{}
+	files, err := ioutil.ReadDir("/dev/disk/by-id")
+	if err != nil {
+		return links
+	}
+	for _, f := range files {
+		if f.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		target, err := os.Readlink(filepath.Join("/dev/disk/by-id", f.Name()))
+		if err != nil {
+			continue
+		}
+		links[filepath.Base(target)] = append(links[filepath.Base(target)], f.Name())
+	}
+	for _, names := range links {
+		sort.Strings(names)
+	}
+	return links
+}
+
+// persistentName returns the preferred by-id link of any of the blocks, or
+// an empty string if none have one
+func persistentName(blocks []string, links map[string][]string) string {
+	for _, prefix := range byIDPrefixes {
+		for _, block := range blocks {
+			for _, name := range links[block] {
+				if strings.HasPrefix(name, prefix) {
+					return filepath.Join("/dev/disk/by-id", name)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// PersistentNames returns a /dev/disk/by-id name for each multipath device,
+// preferring the device-mapper multipath map over the WWN and SCSI names of
+// its paths, and falling back to /dev/sdX when there are no links
+func PersistentNames(mpds []*MultiPathDevice) map[*MultiPathDevice]string {
+	links := byIDLinks()
+	names := map[*MultiPathDevice]string{}
+	for _, mpd := range mpds {
+		var blocks []string
+		for _, device := range mpd.Devices() {
+			if device.Block != "" {
+				blocks = append(blocks, device.Block)
+			}
+		}
+		sort.Strings(blocks)
+		name := persistentName(append(mpd.Holders(), blocks...), links)
+		if name == "" && len(blocks) > 0 {
+			name = "/dev/" + blocks[0]
+		}
+		names[mpd] = name
+	}
+	return names
+}
@@ -0,0 +1,55 @@
+package sastopo
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+var (
+	diagMu sync.Mutex
+	// diagnostics are the notable conditions found during the last discovery
+	diagnostics []string
+	// warnings are the problems found during the last discovery
+	warnings []string
+)
+
+// diagnostic logs a notable condition found during discovery and records it
+// so it can be reported later
+func diagnostic(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	diagMu.Lock()
+	diagnostics = append(diagnostics, msg)
+	diagMu.Unlock()
+	log.Printf("Diagnostic: %s", msg)
+}
+
+// warning logs a problem found during discovery and records it so it can be
+// reported later
+func warning(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	diagMu.Lock()
+	warnings = append(warnings, msg)
+	diagMu.Unlock()
+	log.Printf("Warning: %s", msg)
+}
+
+func resetDiagnostics() {
+	diagMu.Lock()
+	diagnostics, warnings = nil, nil
+	diagMu.Unlock()
+}
+
+// Diagnostics returns the diagnostics recorded during the last call to ScsiDevices
+func Diagnostics() []string {
+	diagMu.Lock()
+	defer diagMu.Unlock()
+	return append([]string(nil), diagnostics...)
+}
+
+// Warnings returns the warnings logged during the last call to ScsiDevices
+func Warnings() []string {
+	diagMu.Lock()
+	defer diagMu.Unlock()
+	return append([]string(nil), warnings...)
+}
@@ -0,0 +1,224 @@
+package sastopo
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bensallen/go-sysfs"
+)
+
+// Device-mapper multipath path states. A usable path is active, enabled or
+// disabled after the state of its priority group: the group in use, a
+// standby group or a group multipathd stopped using.
+const (
+	DMPathActive   = "active"
+	DMPathEnabled  = "enabled"
+	DMPathDisabled = "disabled"
+	DMPathFailed   = "failed"
+)
+
+// dmGroupStates are the path states of usable paths by priority group state
+var dmGroupStates = map[string]string{
+	"A": DMPathActive,
+	"E": DMPathEnabled,
+	"D": DMPathDisabled,
+}
+
+// dmPathStatus is the state of a path in a multipath map's status
+type dmPathStatus struct {
+	Group int    // Priority group, from 1
+	State string // DMPathActive, DMPathEnabled, DMPathDisabled or DMPathFailed
+}
+
+// DMDevice is a device-mapper multipath map
+type DMDevice struct {
+	Name   string   // Kernel name, ex: dm-3
+	Map    string   // dm/name, ex: mpatha
+	UUID   string   // dm/uuid, ex: mpath-35000c500a1b2c3d4
+	Slaves []string // Block devices of the map's paths, sorted
+}
+
+// dmMultipathDevices returns the device-mapper multipath maps in sysfs keyed
+// by the block devices of their paths
+func dmMultipathDevices() map[string]*DMDevice {
+	bySlave := map[string]*DMDevice{}
+	for _, obj := range sysfs.Class.Object("block").SubObjectsFilter("dm-*") {
+		uuid, err := obj.Attribute("dm/uuid").Read()
+		if err != nil || !strings.HasPrefix(uuid, "mpath-") {
+			continue
+		}
+		name, _ := obj.Attribute("dm/name").Read()
+		dm := &DMDevice{Name: obj.Name(), Map: name, UUID: uuid}
+		if slaves, err := obj.SubObject("slaves"); err == nil {
+			for _, slave := range slaves.SubObjects() {
+				dm.Slaves = append(dm.Slaves, slave.Name())
+				bySlave[slave.Name()] = dm
+			}
+		}
+		sort.Strings(dm.Slaves)
+	}
+	return bySlave
+}
+
+// dmsetupStatus returns the status of each multipath map keyed by map name
+func dmsetupStatus() (map[string]string, error) {
+	out, err := exec.Command("dmsetup", "status", "--target", "multipath").Output()
+	if err != nil {
+		return nil, fmt.Errorf("dmsetup status failed: %s", err)
+	}
+	status := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if i := strings.Index(line, ": "); i > 0 {
+			status[line[:i]] = line[i+2:]
+		}
+	}
+	return status, nil
+}
+
+// parseDMStatus returns the group and state of each path of a multipath
+// map's status, keyed by the path's major:minor. The status is:
+// <start> <length> multipath <#features> <features>... <#handler args>
+// <handler args>... <#groups> <next group> then for each group <state>
+// <#ps args> <ps args>... <#paths> <#selector args> then for each path
+// <major:minor> <A|F> <fail count> <selector args>...
+func parseDMStatus(status string) map[string]dmPathStatus {
+	states := map[string]dmPathStatus{}
+	f := strings.Fields(status)
+	i := 0
+	for i < len(f) && f[i] != "multipath" {
+		i++
+	}
+	i++
+
+	// next returns the field at i as a number and moves past it
+	next := func() int {
+		if i >= len(f) {
+			i++
+			return 0
+		}
+		n, _ := strconv.Atoi(f[i])
+		i++
+		return n
+	}
+	i += next() // features
+	i += next() // handler args
+	groups := next()
+	next() // next group
+	for g := 0; g < groups && i < len(f); g++ {
+		groupState := dmGroupStates[f[i]]
+		i++
+		i += next() // path selector args
+		paths := next()
+		selectorArgs := next()
+		for p := 0; p < paths && i+2 < len(f); p++ {
+			state := groupState
+			if f[i+1] == "F" || state == "" {
+				state = DMPathFailed
+			}
+			states[f[i]] = dmPathStatus{Group: g + 1, State: state}
+			i += 3 + selectorArgs
+		}
+	}
+	return states
+}
+
+// updateDM links each multipath device to the device-mapper map built on its
+// paths, and sets the device-mapper state of each path
+func updateDM(multiPathDevices map[string]*MultiPathDevice) {
+	bySlave := dmMultipathDevices()
+	if len(bySlave) == 0 {
+		return
+	}
+	status, err := dmsetupStatus()
+	if err != nil {
+		warning("%s", err)
+	}
+
+	for _, mpd := range multiPathDevices {
+		for device := range mpd.Paths {
+			dm := bySlave[device.Block]
+			if dm == nil {
+				continue
+			}
+			if mpd.DM == nil {
+				mpd.DM = dm
+			}
+			dev, err := sysfs.Class.Object("block/" + device.Block).Attribute("dev").Read()
+			if err != nil {
+				continue
+			}
+			path := parseDMStatus(status[dm.Map])[dev]
+			device.DMGroup, device.DMState = path.Group, path.State
+		}
+	}
+}
+
+// DMMismatches describes where device-mapper multipath and sastopo disagree
+// on the paths of a drive: drives sastopo sees several paths to without a
+// map, paths left out of the drive's map and maps spanning several drives
+func DMMismatches(multiPathDevices map[string]*MultiPathDevice) []string {
+	var (
+		mismatches []string
+		seen       = map[*MultiPathDevice]bool{}
+		maps       = map[*DMDevice][]*MultiPathDevice{}
+	)
+	for _, mpd := range multiPathDevices {
+		if seen[mpd] {
+			continue
+		}
+		seen[mpd] = true
+
+		var blocks, unmapped []string
+		for device := range mpd.Paths {
+			if device.Type != 0 || device.Block == "" {
+				continue
+			}
+			blocks = append(blocks, device.Block)
+			if mpd.DM == nil || !containsString(mpd.DM.Slaves, device.Block) {
+				unmapped = append(unmapped, device.Block)
+			}
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		sort.Strings(blocks)
+		sort.Strings(unmapped)
+
+		if mpd.DM == nil {
+			if len(blocks) > 1 {
+				mismatches = append(mismatches, fmt.Sprintf("%s: %d paths (%s) but no device-mapper multipath map", mpd.Serial(), len(blocks), strings.Join(blocks, ",")))
+			}
+			continue
+		}
+		maps[mpd.DM] = append(maps[mpd.DM], mpd)
+		if len(unmapped) > 0 {
+			mismatches = append(mismatches, fmt.Sprintf("%s: paths %s are not in %s (%s)", mpd.Serial(), strings.Join(unmapped, ","), mpd.DM.Map, mpd.DM.Name))
+		}
+	}
+
+	for dm, mpds := range maps {
+		if len(mpds) > 1 {
+			var serials []string
+			for _, mpd := range mpds {
+				serials = append(serials, mpd.Serial())
+			}
+			sort.Strings(serials)
+			mismatches = append(mismatches, fmt.Sprintf("%s (%s): paths of different drives %s", dm.Map, dm.Name, strings.Join(serials, ", ")))
+		}
+	}
+	sort.Strings(mismatches)
+	return mismatches
+}
+
+// containsString returns true if s is in list
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
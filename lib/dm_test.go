@@ -0,0 +1,72 @@
+package sastopo
+
+import "testing"
+
+func TestParseDMStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		want   map[string]dmPathStatus
+	}{
+		{
+			"0 41943040 multipath 2 0 0 0 1 1 A 0 2 2 8:16 A 0 0 1 8:32 F 1 0 1",
+			map[string]dmPathStatus{"8:16": {1, DMPathActive}, "8:32": {1, DMPathFailed}},
+		},
+		{
+			// Two groups with a hardware handler and queue_if_no_path, the
+			// second group on standby with one failed path
+			"0 41943040 multipath 3 1 queue_if_no_path 0 1 alua 2 1 A 0 1 2 8:16 A 0 0 1 E 0 2 2 8:48 A 0 0 1 8:64 F 2 0 1",
+			map[string]dmPathStatus{"8:16": {1, DMPathActive}, "8:48": {2, DMPathEnabled}, "8:64": {2, DMPathFailed}},
+		},
+		{"", map[string]dmPathStatus{}},
+	}
+	for _, tt := range tests {
+		got := parseDMStatus(tt.status)
+		if len(got) != len(tt.want) {
+			t.Errorf("parseDMStatus(%q) = %v, want %v", tt.status, got, tt.want)
+			continue
+		}
+		for dev, path := range tt.want {
+			if got[dev] != path {
+				t.Errorf("parseDMStatus(%q)[%s] = %v, want %v", tt.status, dev, got[dev], path)
+			}
+		}
+	}
+}
+
+func TestDMMismatches(t *testing.T) {
+	path := func(block string) *Device { return &Device{Type: 0, Block: block} }
+	mpd := func(serial string, dm *DMDevice, paths ...*Device) *MultiPathDevice {
+		m := &MultiPathDevice{Paths: map[*Device]bool{}, DM: dm}
+		for _, p := range paths {
+			p.Serial = serial
+			m.Paths[p] = true
+		}
+		return m
+	}
+	good := &DMDevice{Name: "dm-0", Map: "mpatha", Slaves: []string{"sda", "sdb"}}
+	partial := &DMDevice{Name: "dm-1", Map: "mpathb", Slaves: []string{"sdc"}}
+	shared := &DMDevice{Name: "dm-2", Map: "mpathc", Slaves: []string{"sdf", "sdg"}}
+
+	multiPathDevices := map[string]*MultiPathDevice{
+		"A": mpd("A", good, path("sda"), path("sdb")),
+		"B": mpd("B", partial, path("sdc"), path("sdd")),
+		"C": mpd("C", nil, path("sde"), path("sdh")),
+		"D": mpd("D", shared, path("sdf")),
+		"E": mpd("E", shared, path("sdg")),
+		"F": mpd("F", nil, path("sdi")),
+	}
+	got := DMMismatches(multiPathDevices)
+	want := []string{
+		"B: paths sdd are not in mpathb (dm-1)",
+		"C: 2 paths (sde,sdh) but no device-mapper multipath map",
+		"mpathc (dm-2): paths of different drives D, E",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("DMMismatches() = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("DMMismatches()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
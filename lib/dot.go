@@ -0,0 +1,285 @@
+package sastopo
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bensallen/go-sysfs"
+)
+
+// dotGraph collects the nodes, clusters and edges of a Graphviz graph
+type dotGraph struct {
+	nodes    map[string]string      // Node ID to attributes
+	clusters map[string][]string    // Cluster label to node IDs
+	edges    map[[2]string][]string // From and to node IDs to the attributes of each edge
+}
+
+func (g *dotGraph) node(id, attrs string) {
+	if _, ok := g.nodes[id]; !ok {
+		g.nodes[id] = attrs
+	}
+}
+
+// edge adds an edge unless the same one has already been added. Paths to a
+// drive through the same expander are distinct edges.
+func (g *dotGraph) edge(from, to, attrs string) {
+	key := [2]string{from, to}
+	for _, a := range g.edges[key] {
+		if a == attrs {
+			return
+		}
+	}
+	g.edges[key] = append(g.edges[key], attrs)
+}
+
+func (g *dotGraph) cluster(label, id string) {
+	for _, n := range g.clusters[label] {
+		if n == id {
+			return
+		}
+	}
+	g.clusters[label] = append(g.clusters[label], id)
+}
+
+// dotQuote quotes a DOT ID or label, keeping \n as a line break
+func dotQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + strings.Replace(s, "\n", `\n`, -1) + `"`
+}
+
+// pciRoot returns the PCI root complex in the HBA's sysfs path, ex: pci0000:80
+func pciRoot(hba *HBA) string {
+	for _, p := range strings.Split(string(hba.sysfsObj), "/") {
+		if strings.HasPrefix(p, "pci") {
+			return p
+		}
+	}
+	return "pci"
+}
+
+// linkLabel describes the lanes and rates of a SAS link, ex: 4x 12.0 Gbit,
+// and whether it is degraded: a lane not up or slower than the fastest
+func linkLabel(phys map[*Phy]bool) (string, bool) {
+	rates := map[string]int{}
+	fastest := 0.0
+	for phy := range phys {
+		rates[phy.LinkRate]++
+		if mbps := sasLinkMBps(phy.LinkRate); mbps > fastest {
+			fastest = mbps
+		}
+	}
+	var keys []string
+	degraded := false
+	for rate := range rates {
+		keys = append(keys, rate)
+		if sasLinkMBps(rate) < fastest || sasLinkMBps(rate) == 0 {
+			degraded = true
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if sasLinkMBps(keys[i]) != sasLinkMBps(keys[j]) {
+			return sasLinkMBps(keys[i]) > sasLinkMBps(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	var s []string
+	for _, rate := range keys {
+		if rate == "" {
+			rate = "unknown"
+		}
+		s = append(s, fmt.Sprintf("%dx %s", rates[rate], rate))
+	}
+	return strings.Join(s, ", "), degraded
+}
+
+// linkAttrs returns the DOT edge attributes of a SAS link
+func linkAttrs(phys map[*Phy]bool) string {
+	label, degraded := linkLabel(phys)
+	if degraded {
+		return fmt.Sprintf("label=%s, color=red, penwidth=%d", dotQuote(label+"\ndegraded"), len(phys)+1)
+	}
+	return fmt.Sprintf("label=%s, penwidth=%d", dotQuote(label), len(phys)+1)
+}
+
+// sortedPhyIds returns the phy identifiers of a port in numeric order
+func sortedPhyIds(phys map[*Phy]bool) []string {
+	var ids []string
+	for phy := range phys {
+		ids = append(ids, phy.PhyIdentifier)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, _ := strconv.Atoi(ids[i])
+		b, _ := strconv.Atoi(ids[j])
+		return a < b
+	})
+	return ids
+}
+
+// addPath adds the HBA port and expanders in a device's sysfs path to the
+// graph and returns the node the device is attached to
+func (g *dotGraph) addPath(device *Device, expanderEnclosure map[string]string) string {
+	hbaID := "hba:" + device.HBA.PciID
+	portID := hbaID + "/" + device.Port
+	var phys map[*Phy]bool
+	if port := device.HBA.Port(device.Port); port != nil {
+		phys = port.Phys
+	}
+	label, _ := linkLabel(phys)
+	g.node(portID, fmt.Sprintf("shape=box, label=%s", dotQuote(fmt.Sprintf("%s\nphys %s\n%s", device.Port, strings.Join(sortedPhyIds(phys), ","), label))))
+	g.edge(hbaID, portID, "")
+
+	prev := portID
+	p := strings.Split(string(device.sysfsObj), "/")
+	i := 0
+	for _, expander := range device.expanders() {
+		for p[i] != expander {
+			i++
+		}
+		id := "expander:" + expander
+		g.node(id, fmt.Sprintf("shape=diamond, label=%s", dotQuote(expander)))
+		if encl, ok := expanderEnclosure[expander]; ok {
+			g.cluster(encl, id)
+		}
+		// The link into the first expander is the HBA port, later links are
+		// the upstream expander's port before this one
+		if prev != portID {
+			phys = portPhys(sysfs.Object(strings.Join(p[:i], "/")))
+		}
+		g.edge(prev, id, linkAttrs(phys))
+		prev = id
+	}
+	return prev
+}
+
+// DOT returns a Graphviz graph of the SAS domain: PCI roots, HBAs, HBA ports
+// with their phys and link rates, expanders, enclosures and end devices.
+// Links are labelled with their lanes and rates, and links or paths that are
+// degraded are drawn red. Expanders, SES devices and drives are clustered by
+// enclosure.
+func DOT(devices map[string]*Device, enclosures map[*Enclosure]bool, HBAs map[string]*HBA) string {
+	g := &dotGraph{nodes: map[string]string{}, clusters: map[string][]string{}, edges: map[[2]string][]string{}}
+
+	for _, hba := range HBAs {
+		root := "pci:" + pciRoot(hba)
+		g.node(root, fmt.Sprintf("shape=box, style=rounded, label=%s", dotQuote(pciRoot(hba))))
+		label := hba.String()
+		if hba.Inventory.BoardName != "" {
+			label += "\n" + hba.Inventory.BoardName
+		}
+		if hba.Link.CurrentWidth > 0 {
+			label += fmt.Sprintf("\nPCIe %s x%d", hba.Link.CurrentSpeed, hba.Link.CurrentWidth)
+		}
+		g.node("hba:"+hba.PciID, fmt.Sprintf("shape=box3d, label=%s", dotQuote(label)))
+		attrs := ""
+		if hba.Link.CurrentWidth > 0 && hba.Link.CurrentWidth < hba.Link.MaxWidth {
+			attrs = fmt.Sprintf("label=%s, color=red", dotQuote(fmt.Sprintf("x%d of x%d\ndegraded", hba.Link.CurrentWidth, hba.Link.MaxWidth)))
+		}
+		g.edge(root, "hba:"+hba.PciID, attrs)
+	}
+
+	// Expanders belong to the enclosure whose SES device is attached to them,
+	// then to the enclosure of the drives attached to them
+	expanderEnclosure := map[string]string{}
+	for enclosure := range enclosures {
+		for device := range enclosure.MultiPathDevice.Paths {
+			if e := device.expanders(); len(e) > 0 {
+				expanderEnclosure[e[len(e)-1]] = enclosure.Name()
+			}
+		}
+	}
+	for _, device := range devices {
+		if e := device.expanders(); device.Type == 0 && device.Enclosure != nil && len(e) > 0 {
+			if _, ok := expanderEnclosure[e[len(e)-1]]; !ok {
+				expanderEnclosure[e[len(e)-1]] = device.Enclosure.Name()
+			}
+		}
+	}
+
+	for enclosure := range enclosures {
+		id := "enclosure:" + enclosure.Serial()
+		g.node(id, fmt.Sprintf("shape=folder, label=%s", dotQuote(fmt.Sprintf("%s\n%s %s", enclosure.Name(), enclosure.Vendor(), enclosure.Model()))))
+		g.cluster(enclosure.Name(), id)
+		for device := range enclosure.MultiPathDevice.Paths {
+			if device.HBA != nil {
+				g.edge(g.addPath(device, expanderEnclosure), id, fmt.Sprintf("label=%s", dotQuote(device.SG)))
+			}
+		}
+	}
+
+	for _, device := range devices {
+		if device.Type != 0 || device.HBA == nil || device.MultiPath == nil {
+			continue
+		}
+		mpd := device.MultiPath
+		id := "drive:" + mpd.Serial()
+		label := fmt.Sprintf("%s\n%s %s", mpd.Serial(), mpd.Vendor(), mpd.Model())
+		if t := mpd.Target(); t.Enclosure != nil {
+			label = fmt.Sprintf("slot %d\n%s", t.Slot, label)
+			g.cluster(t.Enclosure.Name(), id)
+		}
+		g.node(id, fmt.Sprintf("shape=cylinder, label=%s", dotQuote(label)))
+
+		attrs := fmt.Sprintf("label=%s", dotQuote(device.Block))
+		if (device.State != "" && device.State != "running") || device.DMState == DMPathFailed {
+			state := device.State
+			if device.DMState == DMPathFailed {
+				state += " " + DMPathFailed
+			}
+			attrs = fmt.Sprintf("label=%s, color=red, style=dashed", dotQuote(device.Block+"\n"+strings.TrimSpace(state)))
+		}
+		g.edge(g.addPath(device, expanderEnclosure), id, attrs)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("digraph sas {\n\trankdir=LR;\n\tnode [fontname=\"sans-serif\", fontsize=10];\n\tedge [fontname=\"sans-serif\", fontsize=9];\n")
+
+	var ids []string
+	for id := range g.nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		fmt.Fprintf(&b, "\t%s [%s];\n", dotQuote(id), g.nodes[id])
+	}
+
+	var clusters []string
+	for label := range g.clusters {
+		clusters = append(clusters, label)
+	}
+	sort.Strings(clusters)
+	for i, label := range clusters {
+		fmt.Fprintf(&b, "\tsubgraph cluster_%d {\n\t\tlabel=%s;\n", i, dotQuote("Enclosure "+label))
+		members := append([]string(nil), g.clusters[label]...)
+		sort.Strings(members)
+		for _, id := range members {
+			fmt.Fprintf(&b, "\t\t%s;\n", dotQuote(id))
+		}
+		b.WriteString("\t}\n")
+	}
+
+	var edges [][2]string
+	for e := range g.edges {
+		edges = append(edges, e)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i][0] != edges[j][0] {
+			return edges[i][0] < edges[j][0]
+		}
+		return edges[i][1] < edges[j][1]
+	})
+	for _, e := range edges {
+		for _, attrs := range g.edges[e] {
+			if attrs != "" {
+				fmt.Fprintf(&b, "\t%s -> %s [%s];\n", dotQuote(e[0]), dotQuote(e[1]), attrs)
+			} else {
+				fmt.Fprintf(&b, "\t%s -> %s;\n", dotQuote(e[0]), dotQuote(e[1]))
+			}
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
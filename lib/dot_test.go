@@ -0,0 +1,50 @@
+package sastopo
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bensallen/go-sysfs"
+)
+
+func TestDOT(t *testing.T) {
+	// An HBA on the root bus, with no bridge between it and the root complex
+	const pci = "/sys/devices/pci0000:80/0000:85:00.0"
+	hba := &HBA{
+		PciID:    "0000:85:00.0",
+		Slot:     "C5",
+		sysfsObj: sysfs.Object(pci),
+		Ports: map[*HBAPort]bool{{PortID: "port-1:0", Phys: map[*Phy]bool{
+			{PhyIdentifier: "0", LinkRate: "12.0 Gbit"}: true,
+			{PhyIdentifier: "1", LinkRate: "12.0 Gbit"}: true,
+			{PhyIdentifier: "2", LinkRate: "6.0 Gbit"}:  true,
+			{PhyIdentifier: "3", LinkRate: "12.0 Gbit"}: true,
+		}}: true},
+	}
+
+	encl := &Enclosure{MultiPathDevice: &MultiPathDevice{Paths: map[*Device]bool{}}, Label: "e01"}
+	ses := &Device{ID: "1:0:0:0", Type: 13, Serial: "SN-ENCL", SG: "sg0", HBA: hba, Port: "port-1:0",
+		sysfsObj: sysfs.Object(pci + "/host1/port-1:0/expander-1:0/port-1:0:24/end_device-1:0:24/target1:0:24/1:0:0:0")}
+	encl.MultiPathDevice.Paths[ses] = true
+
+	mpd := &MultiPathDevice{Paths: map[*Device]bool{}}
+	disk := &Device{ID: "1:0:1:0", Type: 0, Serial: "SN-DISK", Block: "sdb", State: "offline", HBA: hba, Port: "port-1:0",
+		Enclosure: encl, Slot: 3, SlotSource: "ses", MultiPath: mpd,
+		sysfsObj: sysfs.Object(pci + "/host1/port-1:0/expander-1:0/port-1:0:3/end_device-1:0:3/target1:0:3/1:0:1:0")}
+	mpd.Paths[disk] = true
+
+	dot := DOT(map[string]*Device{ses.ID: ses, disk.ID: disk}, map[*Enclosure]bool{encl: true}, map[string]*HBA{hba.PciID: hba})
+	for _, want := range []string{
+		`"pci:pci0000:80" -> "hba:0000:85:00.0";`,
+		`"hba:0000:85:00.0" -> "hba:0000:85:00.0/port-1:0";`,
+		`"hba:0000:85:00.0/port-1:0" -> "expander:expander-1:0" [label="3x 12.0 Gbit, 1x 6.0 Gbit\ndegraded", color=red, penwidth=5];`,
+		`"expander:expander-1:0" -> "enclosure:SN-ENCL" [label="sg0"];`,
+		`"expander:expander-1:0" -> "drive:SN-DISK" [label="sdb\noffline", color=red, style=dashed];`,
+		"subgraph cluster_0 {\n\t\tlabel=\"Enclosure e01\";\n\t\t\"drive:SN-DISK\";\n\t\t\"enclosure:SN-ENCL\";\n\t\t\"expander:expander-1:0\";\n\t}",
+		`label="port-1:0\nphys 0,1,2,3\n3x 12.0 Gbit, 1x 6.0 Gbit"`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("expected %s in:\n%s", want, dot)
+		}
+	}
+}
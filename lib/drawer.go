@@ -0,0 +1,114 @@
+package sastopo
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// drawerDescriptor matches a drawer number in an SES element descriptor,
+// ex: "Disk Drawer 2 Slot 14" or "DRAWER1_FAN0"
+var drawerDescriptor = regexp.MustCompile(`(?i)drawer\s*_?(\d+)`)
+
+// Drawer is a group of an enclosure's slots serviced together, with its own
+// expanders, fans and sensors, ex: a drawer of an 84 or 102 bay enclosure.
+// Drawers come from element descriptors, or from secondary subenclosures
+// when descriptors don't name a drawer.
+type Drawer struct {
+	Name         string // ex: Drawer 2 or Subenclosure 1
+	SubEnclosure int    // Subenclosure identifier of the drawer's elements, -1 if they differ
+	Slots        []int
+	Elements     []*SesElement // Every element of the drawer, slots included
+}
+
+// drawerName returns the drawer named by an element descriptor, or an empty
+// string
+func drawerName(descriptor string) string {
+	m := drawerDescriptor.FindStringSubmatch(descriptor)
+	if m == nil {
+		return ""
+	}
+	n, _ := strconv.Atoi(m[1])
+	return fmt.Sprintf("Drawer %d", n)
+}
+
+// updateDrawers groups the enclosure's elements into drawers and sets the
+// Drawer of each element. An element whose descriptor names no drawer joins
+// the drawer of its subenclosure when every named element of the
+// subenclosure is in the same drawer. Otherwise elements of secondary
+// subenclosures with slots form a drawer per subenclosure, and elements of
+// the primary subenclosure, or of a secondary one without slots such as the
+// second ESM of a dual IOM enclosure, are left out of any drawer.
+func (e *Enclosure) updateDrawers() {
+	e.Drawers = nil
+
+	subDrawers := map[int]string{}
+	subSlots := map[int]bool{}
+	for _, el := range e.Elements {
+		if el.IsSlot() {
+			subSlots[el.SubEnclosure] = true
+		}
+		el.Drawer = drawerName(el.Descriptor)
+		if el.Drawer == "" {
+			continue
+		}
+		if d, ok := subDrawers[el.SubEnclosure]; ok && d != el.Drawer {
+			subDrawers[el.SubEnclosure] = ""
+		} else if !ok {
+			subDrawers[el.SubEnclosure] = el.Drawer
+		}
+	}
+	for _, el := range e.Elements {
+		if el.Drawer != "" {
+			continue
+		}
+		if d, ok := subDrawers[el.SubEnclosure]; ok {
+			el.Drawer = d
+		} else if el.SubEnclosure != 0 && len(e.SubEnclosures) > 1 && subSlots[el.SubEnclosure] {
+			el.Drawer = fmt.Sprintf("Subenclosure %d", el.SubEnclosure)
+		}
+	}
+
+	drawers := map[string]*Drawer{}
+	for _, el := range e.Elements {
+		if el.Drawer == "" {
+			continue
+		}
+		d := drawers[el.Drawer]
+		if d == nil {
+			d = &Drawer{Name: el.Drawer, SubEnclosure: el.SubEnclosure}
+			drawers[el.Drawer] = d
+			e.Drawers = append(e.Drawers, d)
+		}
+		if d.SubEnclosure != el.SubEnclosure {
+			d.SubEnclosure = -1
+		}
+		d.Elements = append(d.Elements, el)
+		if el.IsSlot() {
+			d.Slots = append(d.Slots, el.Slot)
+		}
+	}
+	for _, d := range e.Drawers {
+		sort.Ints(d.Slots)
+	}
+	sort.Slice(e.Drawers, func(i, j int) bool {
+		if len(e.Drawers[i].Name) != len(e.Drawers[j].Name) {
+			return len(e.Drawers[i].Name) < len(e.Drawers[j].Name)
+		}
+		return e.Drawers[i].Name < e.Drawers[j].Name
+	})
+}
+
+// Drawer returns the drawer holding the slot, or nil if the slot is not in a
+// drawer
+func (e *Enclosure) Drawer(slot int) *Drawer {
+	for _, d := range e.Drawers {
+		for _, s := range d.Slots {
+			if s == slot {
+				return d
+			}
+		}
+	}
+	return nil
+}
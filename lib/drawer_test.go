@@ -0,0 +1,54 @@
+package sastopo
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestUpdateDrawers(t *testing.T) {
+	encl := &Enclosure{
+		SubEnclosures: []SesSubEnclosure{{ID: 0}, {ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}},
+		Elements: []*SesElement{
+			{Type: SesTypeArrayDeviceSlot, SubEnclosure: 1, Slot: 0, Descriptor: "Disk Drawer 1 Slot 0"},
+			{Type: SesTypeArrayDeviceSlot, SubEnclosure: 1, Slot: 1, Descriptor: "Disk Drawer 1 Slot 1"},
+			{Type: SesTypeArrayDeviceSlot, SubEnclosure: 2, Slot: 2, Descriptor: "DRAWER2 SLOT 0"},
+			{Type: SesTypeSASExpander, SubEnclosure: 2},
+			{Type: 0x03, SubEnclosure: 1, Descriptor: "DRAWER_1_FAN0"},
+			{Type: SesTypeESM, SubEnclosure: 3},
+			{Type: 0x02, SubEnclosure: 0, Descriptor: "PSU A"},
+			{Type: SesTypeArrayDeviceSlot, SubEnclosure: 4, Slot: 3},
+			{Type: 0x03, SubEnclosure: 4},
+		},
+	}
+	encl.updateDrawers()
+
+	want := []struct {
+		name     string
+		slots    []int
+		elements int
+	}{
+		{"Drawer 1", []int{0, 1}, 3},
+		{"Drawer 2", []int{2}, 2},
+		{"Subenclosure 4", []int{3}, 2},
+	}
+	if len(encl.Drawers) != len(want) {
+		t.Fatalf("found %d drawers, want %d", len(encl.Drawers), len(want))
+	}
+	for i, w := range want {
+		d := encl.Drawers[i]
+		if d.Name != w.name || fmt.Sprint(d.Slots) != fmt.Sprint(w.slots) || len(d.Elements) != w.elements {
+			t.Errorf("drawer %d = %s slots %v with %d elements, want %s slots %v with %d elements",
+				i, d.Name, d.Slots, len(d.Elements), w.name, w.slots, w.elements)
+		}
+	}
+	if el := encl.Elements[6]; el.Drawer != "" {
+		t.Errorf("expected power supply in no drawer, found %s", el.Drawer)
+	}
+	// A secondary subenclosure without slots, such as a second ESM, is no drawer
+	if el := encl.Elements[5]; el.Drawer != "" {
+		t.Errorf("expected ESM subenclosure in no drawer, found %s", el.Drawer)
+	}
+	if d := encl.Drawer(2); d == nil || d.Name != "Drawer 2" {
+		t.Errorf("unexpected drawer for slot 2: %v", d)
+	}
+}
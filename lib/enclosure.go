@@ -2,14 +2,21 @@ package sastopo
 
 import (
 	"log"
-	"os"
-	"os/exec"
+	"sort"
 )
 
 // Enclosure is a SCSI Enclosure Device
 type Enclosure struct {
 	MultiPathDevice *MultiPathDevice
 	Slots           map[int]*MultiPathDevice
+	Elements        []*SesElement     // SES elements, in element index order
+	SubEnclosures   []SesSubEnclosure // SES subenclosures, primary first
+	Drawers         []*Drawer         // Drawers of slots, in drawer order
+	Label           string
+	LabelSource     string // Where Label was found: config or hba_port
+	Rack            string
+	U               int
+	Chain           int
 }
 
 func (d *Device) updateEnclosureSerial() (err error) {
@@ -37,35 +44,19 @@ func (d *Device) updateEnclosureSerial() (err error) {
 // The DDN SA4600 and IBM DCS3700 doesn't support vpd_80 for SN.
 // However SES page 0x7 has a vendor specific element [0x8e] that
 // shows a device labeled as "SHELF" or "Dragon Enclosure" in page 0x1.
-// We use sg_ses --hex output, drop all the whitespace and use
-// hex.Decode() to turn it into a useable []byte. Finally we take
-// the appropraite "offset" bytes in the 0x7 page, and grab "length" bytes
-// which makes up the serial number.
+// We take the appropraite "offset" bytes in the 0x7 page, and grab "length"
+// bytes which makes up the serial number.
 // This function requires root privledges and sg3_utils to be installed.
 func sgSesEnclosureSerial(sg string, offset int, length int) (string, error) {
-	var (
-		cmdOut []byte
-		sn     string
-		err    error
-		page7  []byte
-	)
-
-	if _, err = os.Stat("/dev/" + sg); os.IsNotExist(err) {
-		return sn, err
-	}
-
-	cmd := "sg_ses"
-	args := []string{"--page=0x7", "-I7,0", "--raw", "/dev/" + sg}
-	if cmdOut, err = exec.Command(cmd, args...).Output(); err != nil {
-		log.Printf("Error, running sg_ses failed: %s", err)
-		return sn, err
+	page7, err := sgSesPage(sg, sesPageElementDesc)
+	if err != nil {
+		log.Printf("Error, reading SES page 0x7 failed: %s", err)
+		return "", err
 	}
-	page7, n, err := sgSesToBytes(cmdOut)
-	if err != nil || n == 0 {
-		log.Printf("Error, decoding hex output sg_ses failed, found %d bytes: %s", n, err)
-		return sn, err
+	if len(page7) < offset+length {
+		log.Printf("Error, SES page 0x7 too short for serial, found %d bytes", len(page7))
+		return "", ErrShortSesPage
 	}
-	//log.Printf("Found %d bytes of data from sg_ses, serial is: %#v", n, string(page7[2068:2084]))
 	return string(page7[offset : offset+length]), nil
 }
 
@@ -121,3 +112,66 @@ func (e *Enclosure) Model() string {
 	}
 	return ""
 }
+
+// sg returns the first SCSI generic device name found for the Enclosure Device
+func (e *Enclosure) sg() string {
+	for device := range e.MultiPathDevice.Paths {
+		if device.SG != "" {
+			return device.SG
+		}
+	}
+	return ""
+}
+
+// SlotElement returns the SES device slot element for the slot number, or
+// nil if SES did not report one
+func (e *Enclosure) SlotElement(slot int) *SesElement {
+	for _, el := range e.Elements {
+		if el.IsSlot() && el.Slot == slot {
+			return el
+		}
+	}
+	return nil
+}
+
+// SlotElements returns the SES device slot elements of the enclosure
+func (e *Enclosure) SlotElements() []*SesElement {
+	var elements []*SesElement
+	for _, el := range e.Elements {
+		if el.IsSlot() {
+			elements = append(elements, el)
+		}
+	}
+	return elements
+}
+
+// TotalSlots returns the number of bays reported by SES, or the number of
+// populated slots found in sysfs if SES is unavailable
+func (e *Enclosure) TotalSlots() int {
+	if n := len(e.SlotElements()); n > 0 {
+		return n
+	}
+	return len(e.Slots)
+}
+
+// EmptySlots returns the sorted slot numbers, as used by Device.Slot, that SES
+// reports as not installed
+func (e *Enclosure) EmptySlots() []int {
+	var slots []int
+	for _, el := range e.SlotElements() {
+		if !el.Installed() {
+			slots = append(slots, el.Slot)
+		}
+	}
+	sort.Ints(slots)
+	return slots
+}
+
+// PopulatedSlots returns the number of bays SES reports as installed, or the
+// number of populated slots found in sysfs if SES is unavailable
+func (e *Enclosure) PopulatedSlots() int {
+	if n := len(e.SlotElements()); n > 0 {
+		return n - len(e.EmptySlots())
+	}
+	return len(e.Slots)
+}
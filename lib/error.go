@@ -4,3 +4,9 @@ import "errors"
 
 // ErrUnknownType is when a SCSI device is found that isn't a type that we know how to handle
 var ErrUnknownType = errors.New("unknown device type")
+
+// ErrTargetNotFound is when a target doesn't match any device or enclosure slot
+var ErrTargetNotFound = errors.New("target not found")
+
+// ErrTimeout is when a device did not reach the expected state in time
+var ErrTimeout = errors.New("timed out waiting for device")
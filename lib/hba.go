@@ -1,11 +1,229 @@
 package sastopo
 
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bensallen/go-sysfs"
+)
+
 // HBA is a PCI SAS Host-bus Adapter
 type HBA struct {
-	PciID string            // PCI Bus ID
-	Host  string            // SCSI Host ID
-	Slot  string            // Label that describes physical location
-	Ports map[*HBAPort]bool // SAS Ports
+	PciID      string            // PCI Bus ID
+	Host       string            // SCSI Host ID
+	Slot       string            // Label that describes physical location
+	SlotSource string            // Where Slot came from: config, pci_slot or smbios
+	Ports      map[*HBAPort]bool // SAS Ports
+	Inventory  HBAInventory
+	Link       HBALink
+	sysfsObj   sysfs.Object // PCI device
+}
+
+// HBAInventory is the firmware, board and PCI identity of an HBA, as
+// published by the driver (mpt2sas/mpt3sas) under scsi_host and by the PCI
+// device. Attributes the driver doesn't publish are left empty.
+type HBAInventory struct {
+	FirmwareVersion string // version_fw
+	BiosVersion     string // version_bios
+	NvdataVersion   string // version_nvdata_persistent
+	BoardName       string // board_name
+	BoardAssembly   string // board_assembly
+	BoardTracer     string // board_tracer
+	SasAddress      string // host_sas_address
+	IOCResetCount   int    // ioc_reset_count
+	FwQueueDepth    int    // fw_queue_depth
+	Vendor          string // PCI vendor ID
+	Device          string // PCI device ID
+	SubsystemVendor string // PCI subsystem vendor ID
+	SubsystemDevice string // PCI subsystem device ID
+	Driver          string // Kernel driver name
+}
+
+// HBALink is the PCIe link state, NUMA locality and AER error counters of
+// an HBA's PCI device
+type HBALink struct {
+	CurrentSpeed   string // current_link_speed, ex: 8.0 GT/s PCIe
+	CurrentWidth   int    // current_link_width
+	MaxSpeed       string // max_link_speed
+	MaxWidth       int    // max_link_width
+	NumaNode       int    // numa_node, -1 if unknown
+	LocalCPUs      string // local_cpulist
+	AERCorrectable int    // TOTAL_ERR_COR of aer_dev_correctable
+	AERNonFatal    int    // TOTAL_ERR_NONFATAL of aer_dev_nonfatal
+	AERFatal       int    // TOTAL_ERR_FATAL of aer_dev_fatal
+}
+
+// Name returns the HBA's slot label, or PCI ID if it has none, or an empty
+// string for a nil HBA
+func (h *HBA) Name() string {
+	if h == nil {
+		return ""
+	}
+	if h.Slot != "" {
+		return h.Slot
+	}
+	return h.PciID
+}
+
+// String returns the HBA's slot label and PCI ID, ex: C5 (0000:85:00.0)
+func (h *HBA) String() string {
+	if h == nil || h.Slot == "" {
+		return h.Name()
+	}
+	return fmt.Sprintf("%s (%s)", h.Slot, h.PciID)
+}
+
+// updateLink reads the HBA's PCIe link, NUMA and AER attributes
+func (h *HBA) updateLink() {
+	read := func(name string) string {
+		value, _ := h.sysfsObj.Attribute(name).Read()
+		return value
+	}
+	readInt := func(name string) int {
+		value := strings.TrimSpace(read(name))
+		if value == "" {
+			return 0
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			warning("unable to parse %s of HBA %s: %s", name, h.PciID, err)
+		}
+		return n
+	}
+
+	h.Link = HBALink{
+		CurrentSpeed:   read("current_link_speed"),
+		CurrentWidth:   readInt("current_link_width"),
+		MaxSpeed:       read("max_link_speed"),
+		MaxWidth:       readInt("max_link_width"),
+		NumaNode:       -1,
+		LocalCPUs:      read("local_cpulist"),
+		AERCorrectable: parseAER(read("aer_dev_correctable"))["TOTAL_ERR_COR"],
+		AERNonFatal:    parseAER(read("aer_dev_nonfatal"))["TOTAL_ERR_NONFATAL"],
+		AERFatal:       parseAER(read("aer_dev_fatal"))["TOTAL_ERR_FATAL"],
+	}
+	if node, err := strconv.Atoi(read("numa_node")); err == nil {
+		h.Link.NumaNode = node
+	}
+}
+
+// parseAER parses an AER counter attribute of "<name> <count>" lines
+func parseAER(data string) map[string]int {
+	counters := map[string]int{}
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if n, err := strconv.Atoi(fields[1]); err == nil {
+			counters[fields[0]] = n
+		}
+	}
+	return counters
+}
+
+// linkSpeed returns the GT/s of a PCIe link speed attribute, ex: 8.0 GT/s PCIe
+func linkSpeed(speed string) float64 {
+	fields := strings.Fields(speed)
+	if len(fields) == 0 {
+		return 0
+	}
+	gts, _ := strconv.ParseFloat(fields[0], 64)
+	return gts
+}
+
+// LinkWarnings returns a warning for each way the HBA's PCIe link trained
+// below its maximum speed or width, and if the HBA is not on numaNode, the
+// NUMA node local to the drives' consumers. A negative numaNode skips the
+// NUMA check.
+func (h *HBA) LinkWarnings(numaNode int) []string {
+	var warnings []string
+	l := h.Link
+	if numaNode >= 0 && l.NumaNode >= 0 && l.NumaNode != numaNode {
+		warnings = append(warnings, fmt.Sprintf("HBA %s (%s) is on far NUMA node %d, expected node %d", h.PciID, h.Slot, l.NumaNode, numaNode))
+	}
+	if l.CurrentWidth > 0 && l.CurrentWidth < l.MaxWidth {
+		warnings = append(warnings, fmt.Sprintf("HBA %s (%s) PCIe link downtrained to x%d, capable of x%d", h.PciID, h.Slot, l.CurrentWidth, l.MaxWidth))
+	}
+	if cur, max := linkSpeed(l.CurrentSpeed), linkSpeed(l.MaxSpeed); cur > 0 && cur < max {
+		warnings = append(warnings, fmt.Sprintf("HBA %s (%s) PCIe link downtrained to %s, capable of %s", h.PciID, h.Slot, l.CurrentSpeed, l.MaxSpeed))
+	}
+	return warnings
+}
+
+// updateInventory reads the HBA's scsi_host and PCI device attributes
+func (h *HBA) updateInventory() {
+	host := sysfs.Class.Object("scsi_host/" + h.Host)
+	read := func(obj sysfs.Object, name string) string {
+		value, _ := obj.Attribute(name).Read()
+		return value
+	}
+	readInt := func(obj sysfs.Object, name string) int {
+		value := strings.TrimSpace(read(obj, name))
+		if value == "" {
+			return 0
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			warning("unable to parse %s of HBA %s: %s", name, h.PciID, err)
+		}
+		return n
+	}
+
+	h.Inventory = HBAInventory{
+		FirmwareVersion: read(host, "version_fw"),
+		BiosVersion:     read(host, "version_bios"),
+		NvdataVersion:   read(host, "version_nvdata_persistent"),
+		BoardName:       read(host, "board_name"),
+		BoardAssembly:   read(host, "board_assembly"),
+		BoardTracer:     read(host, "board_tracer"),
+		SasAddress:      read(host, "host_sas_address"),
+		IOCResetCount:   readInt(host, "ioc_reset_count"),
+		FwQueueDepth:    readInt(host, "fw_queue_depth"),
+		Vendor:          read(h.sysfsObj, "vendor"),
+		Device:          read(h.sysfsObj, "device"),
+		SubsystemVendor: read(h.sysfsObj, "subsystem_vendor"),
+		SubsystemDevice: read(h.sysfsObj, "subsystem_device"),
+	}
+	if driver, err := filepath.EvalSymlinks(string(h.sysfsObj) + "/driver"); err == nil {
+		h.Inventory.Driver = filepath.Base(driver)
+	}
+}
+
+// FirmwareBelow returns true if the HBA's firmware version is known and
+// lower than min
+func (h *HBA) FirmwareBelow(min string) bool {
+	if h.Inventory.FirmwareVersion == "" || min == "" {
+		return false
+	}
+	return compareVersions(h.Inventory.FirmwareVersion, min) < 0
+}
+
+var versionFields = regexp.MustCompile(`[0-9]+`)
+
+// compareVersions compares the numeric fields of two version strings, ex:
+// 16.00.01.00, returning -1, 0 or 1. Missing fields count as zero.
+func compareVersions(a, b string) int {
+	fa := versionFields.FindAllString(a, -1)
+	fb := versionFields.FindAllString(b, -1)
+	for i := 0; i < len(fa) || i < len(fb); i++ {
+		var na, nb int
+		if i < len(fa) {
+			na, _ = strconv.Atoi(fa[i])
+		}
+		if i < len(fb) {
+			nb, _ = strconv.Atoi(fb[i])
+		}
+		if na < nb {
+			return -1
+		} else if na > nb {
+			return 1
+		}
+	}
+	return 0
 }
 
 // HBAPort is a HBA Port
@@ -18,6 +236,21 @@ type HBAPort struct {
 type Phy struct {
 	PhyIdentifier string //phy_identifier
 	SasAddress    string //sas_address
+	LinkRate      string //negotiated_linkrate
+	Errors        PhyErrors
+}
+
+// PhyErrors are the link error counters of a SAS phy
+type PhyErrors struct {
+	InvalidDword     int // invalid_dword_count
+	RunningDisparity int // running_disparity_error_count
+	LossOfDwordSync  int // loss_of_dword_sync_count
+	PhyResetProblem  int // phy_reset_problem_count
+}
+
+// Total returns the sum of the error counters
+func (e PhyErrors) Total() int {
+	return e.InvalidDword + e.RunningDisparity + e.LossOfDwordSync + e.PhyResetProblem
 }
 
 func (h *HBA) Port(p string) *HBAPort {
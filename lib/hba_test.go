@@ -0,0 +1,67 @@
+package sastopo
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"16.00.01.00", "16.00.01.00", 0},
+		{"14.00.00.00", "16.00.01.00", -1},
+		{"16.00.10.00", "16.00.01.00", 1},
+		{"20.00.07.00", "20.0.7", 0},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, expected %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	hba := &HBA{Inventory: HBAInventory{FirmwareVersion: "14.00.00.00"}}
+	if !hba.FirmwareBelow("16.00.01.00") || hba.FirmwareBelow("") {
+		t.Errorf("unexpected FirmwareBelow result for %s", hba.Inventory.FirmwareVersion)
+	}
+}
+
+func TestLinkWarnings(t *testing.T) {
+	counters := parseAER("RxErr 3\nBadTLP 0\nTOTAL_ERR_COR 3\n")
+	if counters["RxErr"] != 3 || counters["TOTAL_ERR_COR"] != 3 {
+		t.Errorf("unexpected AER counters: %v", counters)
+	}
+
+	hba := &HBA{PciID: "0000:11:00.0", Link: HBALink{
+		CurrentSpeed: "5.0 GT/s PCIe", CurrentWidth: 4,
+		MaxSpeed: "8.0 GT/s PCIe", MaxWidth: 8,
+	}}
+	if w := hba.LinkWarnings(-1); len(w) != 2 {
+		t.Errorf("expected width and speed warnings, found %v", w)
+	}
+	hba.Link.CurrentSpeed, hba.Link.CurrentWidth = hba.Link.MaxSpeed, hba.Link.MaxWidth
+	if w := hba.LinkWarnings(-1); len(w) != 0 {
+		t.Errorf("expected no warnings, found %v", w)
+	}
+
+	hba.Link.NumaNode = 1
+	if w := hba.LinkWarnings(0); len(w) != 1 {
+		t.Errorf("expected a far NUMA node warning, found %v", w)
+	}
+	if w := hba.LinkWarnings(1); len(w) != 0 {
+		t.Errorf("expected no warnings on the local NUMA node, found %v", w)
+	}
+}
+
+func TestHBAName(t *testing.T) {
+	var none *HBA
+	if none.Name() != "" || none.String() != "" {
+		t.Errorf("expected empty names for a nil HBA")
+	}
+	hba := &HBA{PciID: "0000:85:00.0"}
+	if hba.Name() != "0000:85:00.0" || hba.String() != "0000:85:00.0" {
+		t.Errorf("unexpected names without a slot: %s, %s", hba.Name(), hba.String())
+	}
+	hba.Slot = "C5"
+	if hba.Name() != "C5" || hba.String() != "C5 (0000:85:00.0)" {
+		t.Errorf("unexpected names with a slot: %s, %s", hba.Name(), hba.String())
+	}
+}
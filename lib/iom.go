@@ -0,0 +1,153 @@
+package sastopo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bensallen/go-sysfs"
+)
+
+// esmName names an ESM element by its descriptor, or its position when it
+// has none, ex: ESM 0
+func esmName(el *SesElement) string {
+	if el.Descriptor != "" {
+		return el.Descriptor
+	}
+	return fmt.Sprintf("ESM %d", el.TypeIndex)
+}
+
+// esm returns the enclosure's ESM with the expander of the given SAS
+// address: an ESM reporting the address on one of its phys, or else the ESM
+// in the same position as the SAS expander element with the address
+func (e *Enclosure) esm(sasAddress string) *SesElement {
+	var expander *SesElement
+	for _, el := range e.Elements {
+		if !containsString(el.SasAddresses, sasAddress) {
+			continue
+		}
+		if el.Type == SesTypeESM {
+			return el
+		}
+		if el.Type == SesTypeSASExpander && expander == nil {
+			expander = el
+		}
+	}
+	if expander == nil {
+		return nil
+	}
+	for _, el := range e.Elements {
+		if el.Type == SesTypeESM && el.SubEnclosure == expander.SubEnclosure && el.TypeIndex == expander.TypeIndex {
+			return el
+		}
+	}
+	return nil
+}
+
+// expanderName names the SAS expander element with the given SAS address by
+// its descriptor, or its position when it has none, or returns an empty
+// string if SES doesn't report it
+func (e *Enclosure) expanderName(sasAddress string) string {
+	for _, el := range e.Elements {
+		if el.Type != SesTypeSASExpander || !containsString(el.SasAddresses, sasAddress) {
+			continue
+		}
+		if el.Descriptor != "" {
+			return el.Descriptor
+		}
+		return fmt.Sprintf("Expander %d", el.TypeIndex)
+	}
+	return ""
+}
+
+// IOM returns the name of the enclosure's IOM or ESM with the expander of the
+// given SAS address, then the expander element's own name, or an empty string
+// if SES doesn't report it
+func (e *Enclosure) IOM(sasAddress string) string {
+	if el := e.esm(sasAddress); el != nil {
+		return esmName(el)
+	}
+	return e.expanderName(sasAddress)
+}
+
+// upstreamIOM returns the first of the expander SAS addresses, ordered from
+// the device upstream, that belongs to an ESM and the ESM's name, such as a
+// drawer expander cascaded from an IOM's expander. Only if none does is the
+// first expander SES reports named after its own element.
+func (e *Enclosure) upstreamIOM(sasAddresses []string) (string, string) {
+	var fallback, fallbackAddr string
+	for _, addr := range sasAddresses {
+		if el := e.esm(addr); el != nil {
+			return addr, esmName(el)
+		}
+		if name := e.expanderName(addr); name != "" && fallback == "" {
+			fallback, fallbackAddr = name, addr
+		}
+	}
+	return fallbackAddr, fallback
+}
+
+// updateIOM sets the SAS address of the expander the device is attached
+// through and the IOM it belongs to in the device's enclosure
+func (d *Device) updateIOM() {
+	var addrs []string
+	expanders := d.expanders()
+	for i := len(expanders) - 1; i >= 0; i-- {
+		addr, err := sysfs.Class.Object("sas_device/" + expanders[i]).Attribute("sas_address").Read()
+		if err != nil {
+			warning("%s: %s", d.ID, err)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		return
+	}
+	d.Expander = addrs[0]
+	if d.Enclosure == nil {
+		return
+	}
+	if addr, iom := d.Enclosure.upstreamIOM(addrs); iom != "" {
+		d.Expander, d.IOM = addr, iom
+	}
+}
+
+// updateIOMs sets the expander and IOM of every disk
+func updateIOMs(devices map[string]*Device) {
+	for _, device := range devices {
+		if device.Type == 0 {
+			device.updateIOM()
+		}
+	}
+}
+
+// IOMMismatches returns a description of each drive with more than one path
+// through the same IOM, which leaves the drive without a redundant path
+// should that IOM fail. Paths with no known IOM are not checked.
+func IOMMismatches(multiPathDevices map[string]*MultiPathDevice) []string {
+	var mismatches []string
+	for _, mpd := range multiPathDevices {
+		if len(mpd.Paths) < 2 {
+			continue
+		}
+		byIOM := map[string][]string{}
+		for device := range mpd.Paths {
+			if device.IOM != "" {
+				byIOM[device.IOM] = append(byIOM[device.IOM], device.ID)
+			}
+		}
+		for iom, ids := range byIOM {
+			if len(ids) < 2 {
+				continue
+			}
+			sort.Strings(ids)
+			drive := mpd.Serial()
+			if t := mpd.Target(); t.Enclosure != nil {
+				drive += fmt.Sprintf(" (%s:%d)", t.Enclosure.Name(), t.Slot)
+			}
+			mismatches = append(mismatches, fmt.Sprintf("%s: paths %s all go through %s", drive, strings.Join(ids, ","), iom))
+		}
+	}
+	sort.Strings(mismatches)
+	return mismatches
+}
@@ -0,0 +1,76 @@
+package sastopo
+
+import "testing"
+
+func TestEnclosureIOM(t *testing.T) {
+	encl := &Enclosure{Elements: []*SesElement{
+		{Type: SesTypeSASExpander, TypeIndex: 0, SasAddresses: []string{"0x500a0b8000000010"}},
+		{Type: SesTypeSASExpander, TypeIndex: 1, SasAddresses: []string{"0x500a0b8000000020"}},
+		{Type: SesTypeSASExpander, TypeIndex: 2, Descriptor: "Drawer Expander", SasAddresses: []string{"0x500a0b8000000030"}},
+		{Type: SesTypeESM, TypeIndex: 0, Descriptor: "IOM A"},
+		{Type: SesTypeESM, TypeIndex: 1, Descriptor: "IOM B", SasAddresses: []string{"0x500a0b8000000010"}},
+	}}
+
+	tests := []struct {
+		addr string
+		want string
+	}{
+		// An ESM reporting the address wins over the expander's position
+		{"0x500a0b8000000010", "IOM B"},
+		{"0x500a0b8000000020", "IOM B"},
+		{"0x500a0b8000000030", "Drawer Expander"},
+		{"0x500a0b8000000040", ""},
+	}
+	for _, tt := range tests {
+		if got := encl.IOM(tt.addr); got != tt.want {
+			t.Errorf("IOM(%s) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestEnclosureUpstreamIOM(t *testing.T) {
+	encl := &Enclosure{Elements: []*SesElement{
+		{Type: SesTypeSASExpander, SubEnclosure: 1, TypeIndex: 0, Descriptor: "Drawer Expander", SasAddresses: []string{"0x500a0b8000000030"}},
+		{Type: SesTypeSASExpander, TypeIndex: 0, SasAddresses: []string{"0x500a0b8000000010"}},
+		{Type: SesTypeESM, TypeIndex: 0, Descriptor: "IOM A"},
+	}}
+
+	tests := []struct {
+		addrs    []string
+		wantAddr string
+		wantIOM  string
+	}{
+		// The drawer expander nearest the device only matches its own
+		// element, the IOM's expander upstream of it matches an ESM
+		{[]string{"0x500a0b8000000030", "0x500a0b8000000010"}, "0x500a0b8000000010", "IOM A"},
+		{[]string{"0x500a0b8000000030", "0x500a0b8000000040"}, "0x500a0b8000000030", "Drawer Expander"},
+		{[]string{"0x500a0b8000000040"}, "", ""},
+	}
+	for _, tt := range tests {
+		addr, iom := encl.upstreamIOM(tt.addrs)
+		if addr != tt.wantAddr || iom != tt.wantIOM {
+			t.Errorf("upstreamIOM(%v) = %q, %q, want %q, %q", tt.addrs, addr, iom, tt.wantAddr, tt.wantIOM)
+		}
+	}
+}
+
+func TestIOMMismatches(t *testing.T) {
+	mpd := func(serial string, ioms ...string) *MultiPathDevice {
+		m := &MultiPathDevice{Paths: map[*Device]bool{}}
+		for i, iom := range ioms {
+			m.Paths[&Device{ID: "1:0:" + string(rune('0'+i)) + ":0", Serial: serial, IOM: iom}] = true
+		}
+		return m
+	}
+	multiPathDevices := map[string]*MultiPathDevice{
+		"A": mpd("A", "IOM A", "IOM B"),
+		"B": mpd("B", "IOM A", "IOM A"),
+		"C": mpd("C", "IOM A", ""),
+		"D": mpd("D", "IOM B"),
+	}
+	got := IOMMismatches(multiPathDevices)
+	want := "B: paths 1:0:0:0,1:0:1:0 all go through IOM A"
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("IOMMismatches() = %q, want [%q]", got, want)
+	}
+}
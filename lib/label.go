@@ -0,0 +1,84 @@
+package sastopo
+
+import (
+	"sort"
+	"strings"
+)
+
+// LogicalID returns the SES logical identifier of the primary subenclosure,
+// or an empty string if SES was not read
+func (e *Enclosure) LogicalID() string {
+	if len(e.SubEnclosures) == 0 {
+		return ""
+	}
+	return e.SubEnclosures[0].LogicalID
+}
+
+// Name returns the enclosure's label, or its serial if it has none
+func (e *Enclosure) Name() string {
+	if e.Label != "" {
+		return e.Label
+	}
+	return e.Serial()
+}
+
+// Matches returns true if id is the enclosure's serial, label or logical ID
+func (e *Enclosure) Matches(id string) bool {
+	if id == "" {
+		return false
+	}
+	if id == e.Serial() || id == e.Label {
+		return true
+	}
+	return e.LogicalID() != "" && normalizeID(id) == e.LogicalID()
+}
+
+// normalizeID lower cases a hex identifier and strips any 0x prefix
+func normalizeID(id string) string {
+	return strings.TrimPrefix(strings.ToLower(id), "0x")
+}
+
+// updateEnclosureLabels labels each enclosure from conf.Enclosures, keyed by
+// serial or logical ID, otherwise from conf.EnclLabels, keyed by the HBA PCI
+// ID and port of the enclosure's first path
+func updateEnclosureLabels(enclosures map[*Enclosure]bool, conf Conf) {
+	byLogicalID := map[string]EnclosureConf{}
+	for id, c := range conf.Enclosures {
+		byLogicalID[normalizeID(id)] = c
+	}
+
+	for enclosure := range enclosures {
+		c, ok := conf.Enclosures[enclosure.Serial()]
+		if !ok && enclosure.LogicalID() != "" {
+			c, ok = byLogicalID[enclosure.LogicalID()]
+		}
+		if ok {
+			enclosure.Label, enclosure.Rack, enclosure.U, enclosure.Chain = c.Label, c.Rack, c.U, c.Chain
+			if c.Label != "" {
+				enclosure.LabelSource = "config"
+				continue
+			}
+		}
+
+		paths := enclosure.MultiPathDevice.Devices()
+		sort.Slice(paths, func(i, j int) bool {
+			if paths[i].HBA == nil || paths[j].HBA == nil {
+				return paths[j].HBA == nil && paths[i].HBA != nil
+			}
+			if paths[i].HBA.PciID != paths[j].HBA.PciID {
+				return paths[i].HBA.PciID < paths[j].HBA.PciID
+			}
+			return paths[i].Port < paths[j].Port
+		})
+		for _, path := range paths {
+			if path.HBA == nil {
+				continue
+			}
+			if label := conf.EnclLabels[path.HBA.PciID][path.Port]; label != "" {
+				enclosure.Label = label
+				enclosure.LabelSource = "hba_port"
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package sastopo
+
+import "testing"
+
+func TestUpdateEnclosureLabels(t *testing.T) {
+	hba := &HBA{PciID: "0000:85:00.0"}
+	enclosure := func(serial, logicalID, port string) *Enclosure {
+		e := &Enclosure{MultiPathDevice: &MultiPathDevice{Paths: map[*Device]bool{
+			{Serial: serial, HBA: hba, Port: port}: true,
+		}}}
+		if logicalID != "" {
+			e.SubEnclosures = []SesSubEnclosure{{LogicalID: logicalID}}
+		}
+		return e
+	}
+	bySerial := enclosure("SN1", "", "port-1:0")
+	byLogicalID := enclosure("SN2", "5000ccab04000000", "port-1:1")
+	byPort := enclosure("SN3", "", "port-1:2")
+	unlabelled := enclosure("SN4", "", "port-1:3")
+
+	conf := Conf{
+		Enclosures: map[string]EnclosureConf{
+			"SN1":                {Label: "e01", Rack: "R1", U: 40, Chain: 1},
+			"0x5000CCAB04000000": {Label: "e02", Rack: "R1", U: 36, Chain: 2},
+		},
+		EnclLabels: map[string]map[string]string{"0000:85:00.0": {"port-1:2": "e03"}},
+	}
+	updateEnclosureLabels(map[*Enclosure]bool{bySerial: true, byLogicalID: true, byPort: true, unlabelled: true}, conf)
+
+	tests := []struct {
+		enclosure *Enclosure
+		name      string
+		source    string
+		chain     int
+	}{
+		{bySerial, "e01", "config", 1},
+		{byLogicalID, "e02", "config", 2},
+		{byPort, "e03", "hba_port", 0},
+		{unlabelled, "SN4", "", 0},
+	}
+	for _, tt := range tests {
+		if tt.enclosure.Name() != tt.name || tt.enclosure.LabelSource != tt.source || tt.enclosure.Chain != tt.chain {
+			t.Errorf("enclosure %s: got name %s, source %q, chain %d, want %s, %q, %d", tt.enclosure.Serial(),
+				tt.enclosure.Name(), tt.enclosure.LabelSource, tt.enclosure.Chain, tt.name, tt.source, tt.chain)
+		}
+	}
+
+	for _, id := range []string{"SN2", "e02", "5000ccab04000000", "0x5000CCAB04000000"} {
+		if !byLogicalID.Matches(id) {
+			t.Errorf("enclosure SN2 does not match %s", id)
+		}
+	}
+	if byLogicalID.Matches("SN1") || unlabelled.Matches("") {
+		t.Errorf("enclosure matched another enclosure's id")
+	}
+}
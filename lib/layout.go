@@ -0,0 +1,297 @@
+package sastopo
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+)
+
+// Bay states shown in enclosure layouts
+const (
+	BayEmpty    = "empty"
+	BayOK       = "ok"
+	BayFaulted  = "faulted"
+	BayDegraded = "degraded" // Fewer paths than expected, or a path not running
+	BayLocate   = "locate"
+)
+
+// bayCodes are the single character codes of bay states in ASCII layouts
+var bayCodes = map[string]string{
+	BayEmpty:    ".",
+	BayOK:       "o",
+	BayFaulted:  "F",
+	BayDegraded: "D",
+	BayLocate:   "L",
+}
+
+// bayColors are the fill colors of bay states in SVG layouts
+var bayColors = map[string]string{
+	BayEmpty:    "#e0e0e0",
+	BayOK:       "#8fd18f",
+	BayFaulted:  "#e06060",
+	BayDegraded: "#f0c040",
+	BayLocate:   "#60a0f0",
+}
+
+// Layout is the physical arrangement of an enclosure model's bays as seen by
+// someone servicing it, of the whole enclosure or of each drawer when the
+// enclosure has drawers
+type Layout struct {
+	Rows     int  `yaml:"Rows"`
+	Columns  int  `yaml:"Columns"`
+	ByColumn bool `yaml:"ByColumn"` // Slots run top to bottom then left to right, instead of left to right then down
+	BottomUp bool `yaml:"BottomUp"` // Slots start at the bottom row
+}
+
+// modelLayouts are the layouts of known enclosure models, keyed by model
+var modelLayouts = map[string]Layout{
+	// 60 bays in 5 drawers of 3 rows of 4, seen from above
+	"DCS3700": {Rows: 3, Columns: 4},
+}
+
+// defaultLayoutColumns is the width of layouts of unknown enclosure models
+const defaultLayoutColumns = 12
+
+// Bay is a slot placed in a layout grid
+type Bay struct {
+	Slot   int
+	Row    int
+	Column int
+	Label  string // Slot element descriptor
+	State  string
+	Device *MultiPathDevice
+}
+
+// BayGrid is the bays of an enclosure, or of one of its drawers, in rows and
+// columns
+type BayGrid struct {
+	Enclosure *Enclosure
+	Name      string // Drawer name, empty for the whole enclosure
+	Rows      int
+	Columns   int
+	Bays      []*Bay
+}
+
+// layout returns the enclosure's layout from the configured layouts, then
+// the known models, otherwise rows of defaultLayoutColumns
+func (e *Enclosure) layout(layouts map[string]Layout, slots int) Layout {
+	if l, ok := layouts[e.Model()]; ok && l.Rows > 0 && l.Columns > 0 {
+		return l
+	}
+	if l, ok := modelLayouts[e.Model()]; ok {
+		return l
+	}
+	columns := defaultLayoutColumns
+	if slots < columns {
+		columns = slots
+	}
+	if columns == 0 {
+		columns = 1
+	}
+	return Layout{Rows: (slots + columns - 1) / columns, Columns: columns}
+}
+
+// BayState returns the state of an enclosure slot. A lit locate indicator is
+// shown over a fault, so the bay a technician is sent to stands out.
+func (e *Enclosure) BayState(slot int, pathCount int) string {
+	el := e.SlotElement(slot)
+	mpd := e.Slots[slot]
+	switch {
+	case el != nil && el.Ident:
+		return BayLocate
+	case el != nil && (el.Fault || el.PrdFail || el.Status == SesStatusCritical || el.Status == SesStatusUnrecoverable):
+		return BayFaulted
+	case mpd == nil && (el == nil || !el.Installed()):
+		return BayEmpty
+	case mpd == nil:
+		// SES sees a drive but no path to it was found
+		return BayDegraded
+	case pathCount > 0 && len(mpd.Paths) < pathCount:
+		return BayDegraded
+	}
+	for device := range mpd.Paths {
+		if (device.State != "" && device.State != "running") || device.DMState == DMPathFailed {
+			return BayDegraded
+		}
+	}
+	return BayOK
+}
+
+// BayGrids places the enclosure's slots in grids by the layout of its model,
+// one grid per drawer when the enclosure has drawers. Layouts are keyed by
+// enclosure model and override the known models.
+func (e *Enclosure) BayGrids(layouts map[string]Layout, pathCount int) []*BayGrid {
+	type group struct {
+		name  string
+		slots []int
+	}
+	var groups []group
+	for _, d := range e.Drawers {
+		if len(d.Slots) > 0 {
+			groups = append(groups, group{d.Name, d.Slots})
+		}
+	}
+	if len(groups) == 0 {
+		var slots []int
+		if elements := e.SlotElements(); len(elements) > 0 {
+			for _, el := range elements {
+				slots = append(slots, el.Slot)
+			}
+		} else {
+			for slot := range e.Slots {
+				slots = append(slots, slot)
+			}
+		}
+		sort.Ints(slots)
+		groups = append(groups, group{"", slots})
+	}
+
+	var grids []*BayGrid
+	for _, g := range groups {
+		l := e.layout(layouts, len(g.slots))
+		grid := &BayGrid{Enclosure: e, Name: g.name, Rows: l.Rows, Columns: l.Columns}
+		// Slots beyond the layout add rows, or columns when filling by column
+		if l.ByColumn && len(g.slots) > l.Rows*l.Columns {
+			grid.Columns = (len(g.slots) + l.Rows - 1) / l.Rows
+		} else if len(g.slots) > l.Rows*l.Columns {
+			grid.Rows = (len(g.slots) + l.Columns - 1) / l.Columns
+		}
+		for i, slot := range g.slots {
+			bay := &Bay{Slot: slot, State: e.BayState(slot, pathCount), Device: e.Slots[slot]}
+			if l.ByColumn {
+				bay.Row, bay.Column = i%grid.Rows, i/grid.Rows
+			} else {
+				bay.Row, bay.Column = i/grid.Columns, i%grid.Columns
+			}
+			if l.BottomUp {
+				bay.Row = grid.Rows - 1 - bay.Row
+			}
+			if el := e.SlotElement(slot); el != nil {
+				bay.Label = el.Descriptor
+			}
+			grid.Bays = append(grid.Bays, bay)
+		}
+		grids = append(grids, grid)
+	}
+	return grids
+}
+
+// cells returns the grid's bays by row and column, nil where there is no bay
+func (g *BayGrid) cells() [][]*Bay {
+	cells := make([][]*Bay, g.Rows)
+	for r := range cells {
+		cells[r] = make([]*Bay, g.Columns)
+	}
+	for _, bay := range g.Bays {
+		cells[bay.Row][bay.Column] = bay
+	}
+	return cells
+}
+
+// title names the enclosure, and the drawer if any, the grid shows
+func (g *BayGrid) title() string {
+	e := g.Enclosure
+	title := fmt.Sprintf("Enclosure %s", e.Name())
+	if e.Name() != e.Serial() {
+		title += fmt.Sprintf(" (%s)", e.Serial())
+	}
+	if e.Model() != "" {
+		title += ", " + e.Model()
+	}
+	if g.Name != "" {
+		title += ", " + g.Name
+	}
+	return title
+}
+
+// LayoutASCII draws bay grids for a terminal, each bay as its slot number and
+// a state code, followed by a legend
+func LayoutASCII(grids []*BayGrid) string {
+	var b bytes.Buffer
+	for _, g := range grids {
+		width := 1
+		for _, bay := range g.Bays {
+			if n := len(fmt.Sprint(bay.Slot)); n > width {
+				width = n
+			}
+		}
+		border := "+" + strings.Repeat(strings.Repeat("-", width+4)+"+", g.Columns) + "\n"
+
+		fmt.Fprintf(&b, "%s\n", g.title())
+		b.WriteString(border)
+		for _, row := range g.cells() {
+			b.WriteString("|")
+			for _, bay := range row {
+				if bay == nil {
+					fmt.Fprintf(&b, " %*s   |", width, "")
+					continue
+				}
+				fmt.Fprintf(&b, " %*d %s |", width, bay.Slot, bayCodes[bay.State])
+			}
+			b.WriteString("\n")
+			b.WriteString(border)
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "%s healthy  %s empty  %s faulted  %s path degraded  %s locate\n",
+		bayCodes[BayOK], bayCodes[BayEmpty], bayCodes[BayFaulted], bayCodes[BayDegraded], bayCodes[BayLocate])
+	return b.String()
+}
+
+// SVG layout dimensions, in pixels
+const (
+	svgBayWidth  = 56
+	svgBayHeight = 36
+	svgMargin    = 10
+	svgTitle     = 24
+)
+
+// LayoutSVG draws bay grids as one standalone SVG image, each bay colored by
+// state with the drive's serial and slot label as a tooltip
+func LayoutSVG(grids []*BayGrid) string {
+	width := 0
+	height := svgMargin
+	for _, g := range grids {
+		if w := g.Columns*svgBayWidth + 2*svgMargin; w > width {
+			width = w
+		}
+		height += svgTitle + g.Rows*svgBayHeight + svgMargin
+	}
+	legend := []string{BayOK, BayEmpty, BayFaulted, BayDegraded, BayLocate}
+	if w := len(legend)*100 + 2*svgMargin; w > width {
+		width = w
+	}
+	height += svgTitle
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="12">`+"\n", width, height)
+	y := svgMargin
+	for _, g := range grids {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-weight="bold">%s</text>`+"\n", svgMargin, y+16, html.EscapeString(g.title()))
+		y += svgTitle
+		for _, bay := range g.Bays {
+			x := svgMargin + bay.Column*svgBayWidth
+			by := y + bay.Row*svgBayHeight
+			tip := fmt.Sprintf("Slot %d: %s", bay.Slot, bay.State)
+			if bay.Label != "" {
+				tip += ", " + bay.Label
+			}
+			if bay.Device != nil {
+				tip += ", " + bay.Device.Serial()
+			}
+			fmt.Fprintf(&b, `<g><title>%s</title><rect x="%d" y="%d" width="%d" height="%d" rx="3" fill="%s" stroke="#404040"/>`,
+				html.EscapeString(tip), x+1, by+1, svgBayWidth-2, svgBayHeight-2, bayColors[bay.State])
+			fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="middle">%d</text></g>`+"\n", x+svgBayWidth/2, by+svgBayHeight/2+4, bay.Slot)
+		}
+		y += g.Rows*svgBayHeight + svgMargin
+	}
+	for i, state := range legend {
+		x := svgMargin + i*100
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="12" height="12" fill="%s" stroke="#404040"/><text x="%d" y="%d">%s</text>`+"\n",
+			x, y+4, bayColors[state], x+16, y+14, state)
+	}
+	b.WriteString("</svg>\n")
+	return b.String()
+}
@@ -0,0 +1,63 @@
+package sastopo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBayGrids(t *testing.T) {
+	encl := &Enclosure{
+		MultiPathDevice: &MultiPathDevice{Paths: map[*Device]bool{{Model: "JBOD-2U12"}: true}},
+		Slots:           map[int]*MultiPathDevice{},
+	}
+	for slot := 0; slot < 6; slot++ {
+		encl.Elements = append(encl.Elements, &SesElement{Type: SesTypeArrayDeviceSlot, Slot: slot, Status: SesStatusOK})
+		if slot == 1 {
+			continue
+		}
+		mpd := &MultiPathDevice{Paths: map[*Device]bool{}}
+		for p := 0; p < 2; p++ {
+			mpd.Paths[&Device{Type: 0, State: "running"}] = true
+		}
+		encl.Slots[slot] = mpd
+	}
+	encl.Elements[1].Status = SesStatusNotInstalled
+	encl.Elements[2].Fault = true
+	encl.Elements[3].Ident = true
+	for device := range encl.Slots[4].Paths {
+		device.State = "offline"
+		break
+	}
+
+	layouts := map[string]Layout{"JBOD-2U12": {Rows: 2, Columns: 3, ByColumn: true, BottomUp: true}}
+	grids := encl.BayGrids(layouts, 2)
+	if len(grids) != 1 || grids[0].Rows != 2 || grids[0].Columns != 3 {
+		t.Fatalf("unexpected grids: %#v", grids)
+	}
+
+	want := []struct {
+		row, column int
+		state       string
+	}{
+		{1, 0, BayOK},
+		{0, 0, BayEmpty},
+		{1, 1, BayFaulted},
+		{0, 1, BayLocate},
+		{1, 2, BayDegraded},
+		{0, 2, BayOK},
+	}
+	for i, bay := range grids[0].Bays {
+		if bay.Row != want[i].row || bay.Column != want[i].column || bay.State != want[i].state {
+			t.Errorf("slot %d at row %d column %d is %s, want row %d column %d %s",
+				bay.Slot, bay.Row, bay.Column, bay.State, want[i].row, want[i].column, want[i].state)
+		}
+	}
+
+	ascii := LayoutASCII(grids)
+	if !strings.Contains(ascii, "| 1 . | 3 L | 5 o |\n") || !strings.Contains(ascii, "| 0 o | 2 F | 4 D |\n") {
+		t.Errorf("unexpected ASCII layout:\n%s", ascii)
+	}
+	if svg := LayoutSVG(grids); strings.Count(svg, "<rect") != 6+5 {
+		t.Errorf("expected 6 bays and 5 legend entries in SVG:\n%s", svg)
+	}
+}
@@ -0,0 +1,299 @@
+package sastopo
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ListColumn is a column of a device listing
+type ListColumn struct {
+	Name string
+	Help string
+}
+
+// ListColumns are the columns a device listing can show
+var ListColumns = []ListColumn{
+	{"NAME", "multipath map, or block device of the first path; block device of a path"},
+	{"SERIAL", "drive serial"},
+	{"ENCL", "enclosure name"},
+	{"SLOT", "enclosure slot"},
+	{"LABEL", "slot label"},
+	{"DRAWER", "enclosure drawer"},
+	{"PATHS", "number of paths to the drive"},
+	{"HBA", "HBAs of the paths"},
+	{"PORT", "HBA ports of the paths"},
+	{"IOM", "enclosure IOMs of the paths"},
+	{"HCTL", "SCSI IDs of the paths"},
+	{"SG", "SCSI generic devices of the paths"},
+	{"STATE", "bay state of the drive; SCSI device state of a path"},
+	{"VENDOR", "drive vendor"},
+	{"MODEL", "drive model"},
+	{"REV", "drive firmware revision"},
+	{"SIZE", "drive size"},
+	{"WWID", "multipath WWID"},
+}
+
+// DefaultListColumns are the columns shown when none are chosen
+var DefaultListColumns = []string{"NAME", "SERIAL", "ENCL", "SLOT", "PATHS", "HBA", "MODEL", "REV", "SIZE"}
+
+// listAliases are alternative names of columns in filters and sort keys
+var listAliases = map[string]string{"ENCLOSURE": "ENCL", "SCSI": "HCTL"}
+
+// ListColumnName returns the column a name or alias refers to, ignoring
+// case, ex: ENCL for enclosure
+func ListColumnName(name string) (string, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if alias, ok := listAliases[name]; ok {
+		name = alias
+	}
+	for _, c := range ListColumns {
+		if c.Name == name {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unknown column %s", name)
+}
+
+// ListRow is a row of a device listing, column name to value
+type ListRow map[string]string
+
+// humanSize formats a size in bytes with a binary unit, like lsblk, ex: 3.7T
+func humanSize(size int64) string {
+	units := "BKMGTPE"
+	f := float64(size)
+	i := 0
+	for ; f >= 1024 && i < len(units)-1; i++ {
+		f /= 1024
+	}
+	if i == 0 || f >= 10 {
+		return fmt.Sprintf("%.0f%c", f, units[i])
+	}
+	return fmt.Sprintf("%.1f%c", f, units[i])
+}
+
+// listNumber parses a number with an optional binary unit suffix in either
+// case, ex: 3.7T or 2t
+func listNumber(s string) (float64, bool) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	mult := 1.0
+	if n := len(s); n > 1 {
+		if i := strings.IndexByte("BKMGTPE", s[n-1]); i >= 0 {
+			for ; i > 0; i-- {
+				mult *= 1024
+			}
+			s = s[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f * mult, err == nil
+}
+
+// distinct joins the distinct non-empty values in order
+func distinct(values []string) string {
+	var out []string
+	for _, v := range values {
+		if v != "" && !containsString(out, v) {
+			out = append(out, v)
+		}
+	}
+	return strings.Join(out, ",")
+}
+
+// ListRows returns a row for each drive in the view, or for each path to
+// each drive
+func ListRows(v *View, paths bool) []ListRow {
+	var rows []ListRow
+	for _, d := range v.Devices {
+		row := ListRow{
+			"SERIAL": d.Serial,
+			"ENCL":   d.Enclosure,
+			"LABEL":  d.SlotLabel,
+			"DRAWER": d.Drawer,
+			"PATHS":  strconv.Itoa(len(d.Paths)),
+			"STATE":  d.State,
+			"VENDOR": d.Vendor,
+			"MODEL":  d.Model,
+			"REV":    d.Rev,
+			"WWID":   d.WWID,
+		}
+		if d.Slot >= 0 {
+			row["SLOT"] = strconv.Itoa(d.Slot)
+		}
+		if d.Size > 0 {
+			row["SIZE"] = humanSize(d.Size)
+		}
+
+		if paths {
+			for _, p := range d.Paths {
+				r := ListRow{}
+				for k, v := range row {
+					r[k] = v
+				}
+				r["NAME"], r["HBA"], r["PORT"], r["IOM"], r["HCTL"], r["SG"] = p.Block, p.HBA, p.Port, p.IOM, p.ID, p.SG
+				r["STATE"] = p.State
+				if p.DMState == DMPathFailed {
+					r["STATE"] = distinct([]string{p.State, DMPathFailed})
+				}
+				rows = append(rows, r)
+			}
+			continue
+		}
+
+		var hbas, ports, ioms, ids, sgs []string
+		for _, p := range d.Paths {
+			hbas, ports, ioms = append(hbas, p.HBA), append(ports, p.Port), append(ioms, p.IOM)
+			ids, sgs = append(ids, p.ID), append(sgs, p.SG)
+		}
+		row["HBA"], row["PORT"], row["IOM"], row["HCTL"], row["SG"] = distinct(hbas), distinct(ports), distinct(ioms), distinct(ids), distinct(sgs)
+		row["NAME"] = d.Multipath
+		if row["NAME"] == "" && len(d.Paths) > 0 {
+			row["NAME"] = d.Paths[0].Block
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// compareListValues compares two column values, as numbers when both are
+// numbers, and returns -1, 0 or 1
+func compareListValues(a, b string) int {
+	if x, ok := listNumber(a); ok {
+		if y, ok := listNumber(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// SortListRows sorts rows by the given columns, by default physical
+// location: enclosure, slot, serial and name. Rows with an empty value sort
+// last.
+func SortListRows(rows []ListRow, keys []string) error {
+	if len(keys) == 0 {
+		keys = []string{"ENCL", "SLOT", "SERIAL", "NAME"}
+	}
+	columns := make([]string, len(keys))
+	for i, key := range keys {
+		c, err := ListColumnName(key)
+		if err != nil {
+			return err
+		}
+		columns[i] = c
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, c := range columns {
+			a, b := rows[i][c], rows[j][c]
+			if (a == "") != (b == "") {
+				return b == ""
+			}
+			if n := compareListValues(a, b); n != 0 {
+				return n < 0
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+// listCondition is a comparison of a column with a value in a filter
+type listCondition struct {
+	column string
+	op     string
+	value  string
+	re     *regexp.Regexp
+}
+
+// listConditionRe splits a condition into column, operator and value
+var listConditionRe = regexp.MustCompile(`^\s*([A-Za-z]+)\s*(==|!=|<=|>=|=~|!~|=|<|>)\s*(.*?)\s*$`)
+
+func (c listCondition) match(row ListRow) bool {
+	v := row[c.column]
+	switch c.op {
+	case "=", "==":
+		return strings.EqualFold(v, c.value)
+	case "!=":
+		return !strings.EqualFold(v, c.value)
+	case "=~":
+		return c.re.MatchString(v)
+	case "!~":
+		return !c.re.MatchString(v)
+	}
+	if v == "" {
+		return false
+	}
+	n := compareListValues(v, c.value)
+	switch c.op {
+	case "<":
+		return n < 0
+	case "<=":
+		return n <= 0
+	case ">":
+		return n > 0
+	}
+	return n >= 0
+}
+
+// ListFilter selects rows of a device listing
+type ListFilter struct {
+	any [][]listCondition // Any of the groups, every condition of a group
+}
+
+// ParseListFilter parses a filter of column comparisons joined by && and
+// ||, where && binds tighter, ex: enclosure=JBOD3 && paths<2. Comparisons
+// are =, == and != ignoring case, <, <=, > and >= as numbers when both
+// sides are numbers, sizes included, and =~ and !~ regular expressions.
+// Values may be quoted.
+func ParseListFilter(s string) (*ListFilter, error) {
+	f := &ListFilter{}
+	if strings.TrimSpace(s) == "" {
+		return f, nil
+	}
+	for _, group := range strings.Split(s, "||") {
+		var conds []listCondition
+		for _, term := range strings.Split(group, "&&") {
+			m := listConditionRe.FindStringSubmatch(term)
+			if m == nil {
+				return nil, fmt.Errorf("invalid filter condition %q, expected <column><op><value>", strings.TrimSpace(term))
+			}
+			column, err := ListColumnName(m[1])
+			if err != nil {
+				return nil, err
+			}
+			c := listCondition{column: column, op: m[2], value: strings.Trim(m[3], `"'`)}
+			if c.op == "=~" || c.op == "!~" {
+				if c.re, err = regexp.Compile(c.value); err != nil {
+					return nil, err
+				}
+			}
+			conds = append(conds, c)
+		}
+		f.any = append(f.any, conds)
+	}
+	return f, nil
+}
+
+// Match returns true if the row passes the filter
+func (f *ListFilter) Match(row ListRow) bool {
+	if len(f.any) == 0 {
+		return true
+	}
+	for _, conds := range f.any {
+		ok := true
+		for _, c := range conds {
+			ok = ok && c.match(row)
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
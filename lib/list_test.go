@@ -0,0 +1,99 @@
+package sastopo
+
+import "testing"
+
+func TestListRows(t *testing.T) {
+	v := &View{Devices: []*ViewDevice{
+		{Serial: "SN-A", Enclosure: "JBOD3", Slot: 1, Size: 4000787030016, Multipath: "mpatha", State: BayOK,
+			Paths: []ViewPath{{ID: "1:0:1:0", Block: "sdb", HBA: "C5", State: "running"}, {ID: "2:0:1:0", Block: "sdc", HBA: "C6", State: "running", DMState: DMPathFailed}}},
+		{Serial: "SN-B", Slot: -1, Paths: []ViewPath{{ID: "1:0:9:0", Block: "sdz", HBA: "C5"}}},
+	}}
+
+	rows := ListRows(v, false)
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(rows))
+	}
+	if r := rows[0]; r["NAME"] != "mpatha" || r["SLOT"] != "1" || r["PATHS"] != "2" || r["HBA"] != "C5,C6" || r["SIZE"] != "3.6T" {
+		t.Errorf("unexpected row: %v", r)
+	}
+	if r := rows[1]; r["NAME"] != "sdz" || r["SLOT"] != "" || r["SIZE"] != "" {
+		t.Errorf("unexpected row: %v", r)
+	}
+
+	rows = ListRows(v, true)
+	if len(rows) != 3 {
+		t.Fatalf("got %d path rows, want 3", len(rows))
+	}
+	if r := rows[1]; r["NAME"] != "sdc" || r["HBA"] != "C6" || r["STATE"] != "running,"+DMPathFailed || r["SERIAL"] != "SN-A" {
+		t.Errorf("unexpected path row: %v", r)
+	}
+}
+
+func TestSortListRows(t *testing.T) {
+	rows := []ListRow{
+		{"ENCL": "JBOD3", "SLOT": "10", "SERIAL": "C"},
+		{"SERIAL": "D"},
+		{"ENCL": "JBOD3", "SLOT": "9", "SERIAL": "B"},
+		{"ENCL": "JBOD1", "SLOT": "20", "SERIAL": "A"},
+	}
+	if err := SortListRows(rows, nil); err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	for _, r := range rows {
+		got += r["SERIAL"]
+	}
+	if got != "ABCD" {
+		t.Errorf("sorted by location %s, want ABCD", got)
+	}
+	if err := SortListRows(rows, []string{"bogus"}); err == nil {
+		t.Error("expected an error sorting by an unknown column")
+	}
+}
+
+func TestListFilter(t *testing.T) {
+	row := ListRow{"ENCL": "JBOD3", "PATHS": "1", "SIZE": "3.6T", "MODEL": "ST4000NM0023"}
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{"", true},
+		{"enclosure=JBOD3 && paths<2", true},
+		{"encl=jbod3 && paths>=2", false},
+		{"encl=JBOD1 || model=~^ST4", true},
+		{"model!~ST", false},
+		{"size>2T", true},
+		{"size>2t", true},
+		{"size<3.5t", false},
+		{"size<500G", false},
+		{`encl!="JBOD1"`, true},
+		{"slot<5", false},
+	}
+	for _, test := range tests {
+		f, err := ParseListFilter(test.filter)
+		if err != nil {
+			t.Errorf("%q: %s", test.filter, err)
+			continue
+		}
+		if got := f.Match(row); got != test.want {
+			t.Errorf("%q matched %t, want %t", test.filter, got, test.want)
+		}
+	}
+
+	for _, bad := range []string{"paths", "bogus=1", "model=~("} {
+		if _, err := ParseListFilter(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestListColumnName(t *testing.T) {
+	for name, want := range map[string]string{"serial": "SERIAL", "Enclosure": "ENCL", " scsi ": "HCTL"} {
+		if got, err := ListColumnName(name); err != nil || got != want {
+			t.Errorf("ListColumnName(%q) = %s, %v, want %s", name, got, err, want)
+		}
+	}
+	if _, err := ListColumnName("bogus"); err == nil {
+		t.Error("expected an error for an unknown column")
+	}
+}
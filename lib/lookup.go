@@ -0,0 +1,159 @@
+package sastopo
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bensallen/go-sysfs"
+)
+
+// LookupBlock resolves a single block device, given as sdX, /dev/sdX or a
+// sysfs devpath, without scanning the whole topology. Only sysfs is read for
+// the device, its HBA, its enclosure and the other paths to the same drive.
+// The device's own enclosure is queried over SES at most once per
+// sesCacheTTL, its pages are cached in conf.SesCacheDir. Partitions resolve
+// to their disk and device-mapper devices to their first path.
+func LookupBlock(name string, conf Conf) (*Device, error) {
+	resetDiagnostics()
+
+	disks := blockDisks(filepath.Base(name))
+	if len(disks) == 0 {
+		return nil, fmt.Errorf("%s: %s", ErrTargetNotFound, name)
+	}
+	sort.Strings(disks)
+	sysfsObj, err := sysfs.Class.Object("block").SubObject(disks[0] + "/device")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s is not a SCSI device", ErrTargetNotFound, name)
+	}
+
+	d := &Device{ID: sysfsObj.Name(), sysfsObj: sysfsObj, Type: -1}
+	if err := d.updateSysfsAttrs(); err != nil {
+		warning("%s", err)
+	}
+	if d.Type != 0 {
+		return nil, fmt.Errorf("%s: %s is not a disk", ErrTargetNotFound, name)
+	}
+	if err := d.updateSerial(); err != nil {
+		warning("%s", err)
+	}
+	HBAs := map[string]*HBA{}
+	if err := d.updatePathVars(HBAs, conf); err != nil {
+		return nil, err
+	}
+	updateHBASlots(HBAs)
+	if err := d.updateEnclSlot(); err != nil {
+		warning("%s", err)
+	}
+
+	// Find the other paths to the drive from a device-mapper device holding
+	// it, or else by serial, and the enclosure by sysfs path like
+	// updateEnclosure
+	var (
+		paths  = map[*Device]bool{d: true}
+		encls  = map[*Device]bool{}
+		prefix = sysfsPrefix(d.sysfsObj, conf.SysfsMatchPathEncl)
+	)
+	held := false
+	if holders, err := sysfs.Class.Object("block").SubObject(disks[0] + "/holders"); err == nil {
+		for _, holder := range holders.SubObjects() {
+			for _, disk := range blockDisks(holder.Name()) {
+				obj, err := sysfs.Class.Object("block").SubObject(disk + "/device")
+				if err != nil || disk == disks[0] {
+					continue
+				}
+				held = true
+				paths[&Device{ID: obj.Name(), sysfsObj: obj, Type: 0, Serial: d.Serial}] = true
+			}
+		}
+	}
+	for _, obj := range sysfs.Class.Object("scsi_device").SubObjects() {
+		if obj.Name() == d.ID {
+			continue
+		}
+		devObj, err := obj.SubObject("device")
+		if err != nil {
+			continue
+		}
+		devType, err := devObj.Attribute("type").ReadInt()
+		if err != nil {
+			continue
+		}
+		switch devType {
+		case 0:
+			if held {
+				continue
+			}
+			if serial, err := vpd80(devObj); err == nil && serial != "" && serial == d.Serial {
+				paths[&Device{ID: obj.Name(), sysfsObj: devObj, Type: 0, Serial: serial}] = true
+			}
+		case 13:
+			if sysfsPrefix(devObj, conf.SysfsMatchPathEncl) != prefix {
+				continue
+			}
+			encl := &Device{ID: obj.Name(), sysfsObj: devObj, Type: -1}
+			if err := encl.updateSysfsAttrs(); err != nil {
+				warning("%s", err)
+			}
+			encls[encl] = true
+		}
+	}
+	d.MultiPath = &MultiPathDevice{Paths: paths}
+	for path := range paths {
+		path.MultiPath = d.MultiPath
+	}
+
+	if len(encls) > 0 {
+		enclosure := &Enclosure{MultiPathDevice: &MultiPathDevice{Paths: encls}}
+		for encl := range encls {
+			encl.Enclosure = enclosure
+		}
+		d.Enclosure = enclosure
+		devicesBySASAddress := map[string]map[*Device]bool{}
+		if d.SasAddress != "" {
+			devicesBySASAddress[d.SasAddress] = map[*Device]bool{d: true}
+		}
+		if err := enclosure.loadSes(conf.SesCacheDir); err != nil {
+			warning("%s", err)
+		} else {
+			enclosure.numberSlots(map[string]*Device{d.ID: d})
+			enclosure.updateDrawers()
+			enclosure.updateSlotsFromSes(devicesBySASAddress)
+		}
+		updateEnclosureLabels(map[*Enclosure]bool{enclosure: true}, conf)
+		if el := enclosure.SlotElement(d.Slot); d.SlotSource != "" && el != nil {
+			if el.Descriptor != "" {
+				d.SlotLabel = el.Descriptor
+			}
+			d.Drawer = el.Drawer
+		}
+	}
+	d.updateIOM()
+	return d, nil
+}
+
+// sysfsPrefix returns the first n elements of a sysfs path
+func sysfsPrefix(obj sysfs.Object, n int) string {
+	p := strings.Split(string(obj), "/")
+	if n > len(p) {
+		n = len(p)
+	}
+	return strings.Join(p[:n], "/")
+}
+
+// PathIndex returns the position of the device among the paths to its
+// drive, in order of SCSI ID
+func (d *Device) PathIndex() int {
+	if d.MultiPath == nil {
+		return 0
+	}
+	devices := d.MultiPath.Devices()
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	for i, device := range devices {
+		if device == d {
+			return i
+		}
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package sastopo
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bensallen/go-sysfs"
+)
+
+// blockDisks returns the disks backing a block device, following partitions
+// to their disk and device-mapper or md devices to their slaves
+func blockDisks(name string) []string {
+	dev, err := sysfs.Class.Object("block").SubObject(name)
+	if err != nil {
+		return nil
+	}
+	if dev.Attribute("partition").Exists() {
+		return blockDisks(dev.Parent(1).Name())
+	}
+
+	if slaves, err := dev.SubObject("slaves"); err == nil && len(slaves.SubObjects()) > 0 {
+		var disks []string
+		for _, slave := range slaves.SubObjects() {
+			disks = append(disks, blockDisks(slave.Name())...)
+		}
+		return disks
+	}
+	return []string{name}
+}
+
+// SoleMounts returns the mount points of filesystems that are backed only by
+// paths of the multipath device, which would be lost if the device went away
+func (mpd *MultiPathDevice) SoleMounts() ([]string, error) {
+	paths := map[string]bool{}
+	for device := range mpd.Paths {
+		if device.Block != "" {
+			paths[device.Block] = true
+		}
+	}
+
+	f, err := os.Open("/proc/mounts")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], "/dev/") {
+			continue
+		}
+		source, err := filepath.EvalSymlinks(fields[0])
+		if err != nil {
+			continue
+		}
+		disks := blockDisks(filepath.Base(source))
+		sole := len(disks) > 0
+		for _, disk := range disks {
+			if !paths[disk] {
+				sole = false
+				break
+			}
+		}
+		if sole {
+			mounts = append(mounts, fields[1])
+		}
+	}
+	return mounts, scanner.Err()
+}
@@ -0,0 +1,156 @@
+package sastopo
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MultipathOptions selects the optional parts of a generated multipath.conf
+type MultipathOptions struct {
+	BlacklistLocal bool // Blacklist drives that are not in an enclosure
+	Balance        bool // Prefer paths so that active paths spread across HBAs
+}
+
+// wwidPrefixes maps sysfs wwid designator types to the leading digit of the
+// WWID multipath uses, the SCSI name string identifier type
+var wwidPrefixes = map[string]string{"t10.": "1", "eui.": "2", "naa.": "3"}
+
+// WWID returns the WWID device-mapper multipath knows the drive by, from its
+// multipath map if one exists, otherwise from the wwid of its first path
+func (mpd *MultiPathDevice) WWID() string {
+	if mpd.DM != nil {
+		return strings.TrimPrefix(mpd.DM.UUID, "mpath-")
+	}
+	devices := mpd.Devices()
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	for _, device := range devices {
+		wwid, err := device.sysfsObj.Attribute("wwid").Read()
+		if err != nil || len(wwid) < 4 {
+			continue
+		}
+		if prefix, ok := wwidPrefixes[wwid[:4]]; ok {
+			return prefix + wwid[4:]
+		}
+	}
+	return ""
+}
+
+// EnclosureNumbers numbers the enclosures from 1 in order of serial
+func EnclosureNumbers(enclosures map[*Enclosure]bool) map[*Enclosure]int {
+	var sorted []*Enclosure
+	for enclosure := range enclosures {
+		sorted = append(sorted, enclosure)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Serial() < sorted[j].Serial() })
+	numbers := map[*Enclosure]int{}
+	for i, enclosure := range sorted {
+		numbers[enclosure] = i + 1
+	}
+	return numbers
+}
+
+// hostNumber returns the SCSI host number of an HBA, ex: 2 for host2
+func hostNumber(hba *HBA) string {
+	return strings.TrimPrefix(hba.Host, "host")
+}
+
+// preferredHBAs picks one HBA per device, the one of its HBAs preferred by
+// the fewest devices so far, so that active paths spread evenly
+func preferredHBAs(mpds []*MultiPathDevice) map[*MultiPathDevice]*HBA {
+	preferred := map[*MultiPathDevice]*HBA{}
+	count := map[*HBA]int{}
+	for _, mpd := range mpds {
+		var best *HBA
+		for device := range mpd.Paths {
+			hba := device.HBA
+			if hba == nil {
+				continue
+			}
+			if best == nil || count[hba] < count[best] || (count[hba] == count[best] && hba.PciID < best.PciID) {
+				best = hba
+			}
+		}
+		if best != nil {
+			preferred[mpd] = best
+			count[best]++
+		}
+	}
+	return preferred
+}
+
+// aliasUnsafe matches characters not safe in a multipath alias
+var aliasUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// MultipathConf returns a multipath.conf with a multipaths section aliasing
+// every drive in an enclosure slot after its enclosure label, or number when
+// it has none, and slot, ex: e03s17. Local drives can be blacklisted, and
+// each drive can prefer the paths of one HBA, spreading the active paths
+// evenly across HBAs.
+func MultipathConf(multiPathDevices map[string]*MultiPathDevice, enclosures map[*Enclosure]bool, opts MultipathOptions) string {
+	numbers := EnclosureNumbers(enclosures)
+
+	var slotted, local []*MultiPathDevice
+	seen := map[*MultiPathDevice]bool{}
+	for _, mpd := range multiPathDevices {
+		if seen[mpd] {
+			continue
+		}
+		seen[mpd] = true
+		if t := mpd.Target(); t.Enclosure != nil {
+			slotted = append(slotted, mpd)
+		} else if len(mpd.Paths) > 0 && mpd.Devices()[0].Type == 0 {
+			local = append(local, mpd)
+		}
+	}
+	alias := func(mpd *MultiPathDevice) string {
+		t := mpd.Target()
+		if t.Enclosure.Label != "" {
+			return fmt.Sprintf("%ss%02d", aliasUnsafe.ReplaceAllString(t.Enclosure.Label, "_"), t.Slot)
+		}
+		return fmt.Sprintf("e%02ds%02d", numbers[t.Enclosure], t.Slot)
+	}
+	sort.Slice(slotted, func(i, j int) bool { return alias(slotted[i]) < alias(slotted[j]) })
+	sort.Slice(local, func(i, j int) bool { return local[i].Serial() < local[j].Serial() })
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Generated by sastopo generate multipath\n")
+
+	if opts.BlacklistLocal && len(local) > 0 {
+		fmt.Fprintf(&b, "blacklist {\n")
+		for _, mpd := range local {
+			if wwid := mpd.WWID(); wwid != "" {
+				fmt.Fprintf(&b, "\twwid %s # %s\n", wwid, mpd.Serial())
+			}
+		}
+		fmt.Fprintf(&b, "}\n")
+	}
+
+	var preferred map[*MultiPathDevice]*HBA
+	if opts.Balance {
+		preferred = preferredHBAs(slotted)
+	}
+
+	fmt.Fprintf(&b, "multipaths {\n")
+	for _, mpd := range slotted {
+		wwid := mpd.WWID()
+		if wwid == "" {
+			diagnostic("%s: no WWID found, left out of multipath.conf", mpd.Serial())
+			continue
+		}
+		t := mpd.Target()
+		fmt.Fprintf(&b, "\tmultipath {\n")
+		fmt.Fprintf(&b, "\t\twwid %s\n", wwid)
+		fmt.Fprintf(&b, "\t\talias %s # enclosure %s slot %d, serial %s\n", alias(mpd), t.Enclosure.Name(), t.Slot, mpd.Serial())
+		if hba := preferred[mpd]; hba != nil && len(mpd.Paths) > 1 {
+			fmt.Fprintf(&b, "\t\tpath_grouping_policy group_by_prio\n")
+			fmt.Fprintf(&b, "\t\tprio weightedpath\n")
+			fmt.Fprintf(&b, "\t\tprio_args \"hbtl ^%s:.*:.*:.* 50\" # HBA %s\n", hostNumber(hba), hba.String())
+		}
+		fmt.Fprintf(&b, "\t}\n")
+	}
+	fmt.Fprintf(&b, "}\n")
+	return b.String()
+}
@@ -0,0 +1,49 @@
+package sastopo
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPreferredHBAs(t *testing.T) {
+	hba0, hba1 := &HBA{PciID: "0000:01:00.0"}, &HBA{PciID: "0000:02:00.0"}
+	mpds := testZfsDevices(2, 5, hba0, hba1)
+
+	count := map[*HBA]int{}
+	for _, hba := range preferredHBAs(mpds) {
+		count[hba]++
+	}
+	if count[hba0] != 5 || count[hba1] != 5 {
+		t.Errorf("preferred HBAs not balanced: %s %d, %s %d", hba0.PciID, count[hba0], hba1.PciID, count[hba1])
+	}
+
+	// A drive with a single path always prefers its only HBA
+	single := testZfsDevices(1, 1, hba1)
+	if got := preferredHBAs(append(single, mpds...))[single[0]]; got != hba1 {
+		t.Errorf("single path drive prefers %v, want %s", got, hba1.PciID)
+	}
+}
+
+func TestMultipathConf(t *testing.T) {
+	hba0, hba1 := &HBA{PciID: "0000:01:00.0", Host: "host2"}, &HBA{PciID: "0000:02:00.0", Host: "host12"}
+	mpds := testZfsDevices(2, 2, hba0, hba1)
+	multiPathDevices := map[string]*MultiPathDevice{}
+	enclosures := map[*Enclosure]bool{}
+	for i, mpd := range mpds {
+		mpd.DM = &DMDevice{UUID: fmt.Sprintf("mpath-35000c500a000000%d", i)}
+		multiPathDevices[mpd.Serial()] = mpd
+		enclosures[mpd.Target().Enclosure] = true
+	}
+
+	conf := MultipathConf(multiPathDevices, enclosures, MultipathOptions{Balance: true})
+	for _, alias := range []string{"e01s00", "e01s01", "e02s00", "e02s01"} {
+		if !strings.Contains(conf, "alias "+alias+" ") {
+			t.Errorf("expected alias %s:\n%s", alias, conf)
+		}
+	}
+	// Without the anchor host 2 would also match host 12
+	if !strings.Contains(conf, `prio_args "hbtl ^2:.*:.*:.* 50"`) || !strings.Contains(conf, `prio_args "hbtl ^12:.*:.*:.* 50"`) {
+		t.Errorf("expected anchored host patterns:\n%s", conf)
+	}
+}
@@ -0,0 +1,134 @@
+package sastopo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/bensallen/go-sysfs"
+)
+
+// smbiosTypeSystemSlot is the SMBIOS structure type of System Slot records
+const smbiosTypeSystemSlot = 9
+
+// smbiosTypeEnd is the SMBIOS structure type marking the end of the table
+const smbiosTypeEnd = 127
+
+var pciAddress = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+
+// pciSlotLabels returns the physical slot names from /sys/bus/pci/slots
+// keyed by the slot's PCI address without function, ex: 0000:11:00
+func pciSlotLabels() map[string]string {
+	labels := map[string]string{}
+	for _, slot := range sysfs.Bus.Object("pci/slots").SubObjects() {
+		addr, err := slot.Attribute("address").Read()
+		if err != nil || addr == "" {
+			continue
+		}
+		labels[addr] = slot.Name()
+	}
+	return labels
+}
+
+// dmiSlotLabels returns the designations of SMBIOS System Slot records keyed
+// by the PCI address of the slot without function, ex: 0000:11:00
+func dmiSlotLabels() (map[string]string, error) {
+	data, err := ioutil.ReadFile("/sys/firmware/dmi/tables/DMI")
+	if err != nil {
+		return nil, err
+	}
+	return parseDmiSlots(data), nil
+}
+
+// parseDmiSlots parses a raw SMBIOS structure table for System Slot (type 9)
+// records. Each structure is a formatted area of the length in its header
+// followed by a set of NUL terminated strings, ending with an extra NUL.
+func parseDmiSlots(data []byte) map[string]string {
+	labels := map[string]string{}
+
+	for off := 0; off+4 <= len(data); {
+		typ := data[off]
+		length := int(data[off+1])
+		if length < 4 || off+length > len(data) {
+			break
+		}
+		formatted := data[off : off+length]
+
+		// Find the end of the string set
+		end := off + length
+		for end+1 < len(data) && !(data[end] == 0 && data[end+1] == 0) {
+			end++
+		}
+		strs := strings.Split(string(data[off+length:end]), "\x00")
+		off = end + 2
+
+		if typ == smbiosTypeEnd {
+			break
+		}
+		// Segment, bus and device/function were added in SMBIOS 2.6
+		if typ != smbiosTypeSystemSlot || length < 0x11 {
+			continue
+		}
+		designation := int(formatted[0x04])
+		segment := int(formatted[0x0d]) | int(formatted[0x0e])<<8
+		bus := formatted[0x0f]
+		devfn := formatted[0x10]
+		if designation == 0 || designation > len(strs) || (bus == 0xff && devfn == 0xff) {
+			continue
+		}
+		labels[fmt.Sprintf("%04x:%02x:%02x", segment, bus, devfn>>3)] = strings.TrimSpace(strs[designation-1])
+	}
+	return labels
+}
+
+// pciAncestors returns the PCI addresses of the HBA and the bridges above it,
+// nearest first, without their function
+func (h *HBA) pciAncestors() []string {
+	var addrs []string
+	p := strings.Split(string(h.sysfsObj), "/")
+	for i := len(p) - 1; i >= 0; i-- {
+		if pciAddress.MatchString(p[i]) {
+			addrs = append(addrs, p[i][:len(p[i])-2])
+		}
+	}
+	if len(addrs) == 0 && pciAddress.MatchString(h.PciID) {
+		addrs = append(addrs, h.PciID[:len(h.PciID)-2])
+	}
+	return addrs
+}
+
+// findSlotLabel returns the label of the first address found in labels, and
+// source, or empty strings if none are found
+func findSlotLabel(addrs []string, labels map[string]string, source string) (string, string) {
+	for _, addr := range addrs {
+		if label, ok := labels[addr]; ok {
+			return label, source
+		}
+	}
+	return "", ""
+}
+
+// updateHBASlots labels each HBA without a configured label with its
+// physical slot, first from /sys/bus/pci/slots then from SMBIOS System Slot
+// records. The HBA or the nearest bridge above it found in a slot is used.
+func updateHBASlots(HBAs map[string]*HBA) {
+	var (
+		pciSlots map[string]string
+		dmiSlots map[string]string
+	)
+	for _, hba := range HBAs {
+		if hba.Slot != "" {
+			continue
+		}
+		if pciSlots == nil {
+			// A missing SMBIOS table just leaves the HBA unlabelled
+			pciSlots = pciSlotLabels()
+			dmiSlots, _ = dmiSlotLabels()
+		}
+		hba.Slot, hba.SlotSource = findSlotLabel(hba.pciAncestors(), pciSlots, "pci_slot")
+		if hba.Slot == "" {
+			hba.Slot, hba.SlotSource = findSlotLabel(hba.pciAncestors(), dmiSlots, "smbios")
+		}
+	}
+}
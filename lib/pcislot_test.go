@@ -0,0 +1,47 @@
+package sastopo
+
+import "testing"
+
+func testSystemSlot(designation string, segment uint16, bus, devfn byte) []byte {
+	s := make([]byte, 0x11)
+	s[0] = smbiosTypeSystemSlot
+	s[1] = 0x11
+	s[0x04] = 1
+	s[0x0d] = byte(segment)
+	s[0x0e] = byte(segment >> 8)
+	s[0x0f] = bus
+	s[0x10] = devfn
+	s = append(s, designation...)
+	return append(s, 0, 0)
+}
+
+func TestParseDmiSlots(t *testing.T) {
+	var data []byte
+	// A BIOS Information record with strings, which must be skipped
+	data = append(data, 0, 4, 0, 0)
+	data = append(data, "Vendor\x00Version\x00\x00"...)
+	data = append(data, testSystemSlot("PCIE3", 0, 0x11, 0x00)...)
+	data = append(data, testSystemSlot("CPU2 SLOT6", 1, 0x8b, 0x10)...)
+	// Unknown bus and device/function
+	data = append(data, testSystemSlot("PCIE7", 0, 0xff, 0xff)...)
+	data = append(data, smbiosTypeEnd, 4, 0, 0, 0, 0)
+
+	labels := parseDmiSlots(data)
+	want := map[string]string{
+		"0000:11:00": "PCIE3",
+		"0001:8b:02": "CPU2 SLOT6",
+	}
+	if len(labels) != len(want) {
+		t.Errorf("expected %d labels, found %v", len(want), labels)
+	}
+	for addr, label := range want {
+		if labels[addr] != label {
+			t.Errorf("expected %q for %s, found %q", label, addr, labels[addr])
+		}
+	}
+
+	hba := &HBA{PciID: "0000:11:00.0", sysfsObj: "/sys/devices/pci0000:10/0000:10:01.0/0000:11:00.0"}
+	if label, source := findSlotLabel(hba.pciAncestors(), labels, "smbios"); label != "PCIE3" || source != "smbios" {
+		t.Errorf("expected PCIE3 from smbios, found %q from %q", label, source)
+	}
+}
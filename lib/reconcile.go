@@ -0,0 +1,91 @@
+package sastopo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kinds of Finding
+const (
+	FindingNoDevice     = "bay populated but no OS device"
+	FindingNotInstalled = "OS device but bay reports not installed"
+	FindingNoBay        = "OS device with no bay"
+	FindingBayFault     = "bay reports fault"
+)
+
+// Finding is a disagreement between the SES view of an enclosure's bays and
+// the OS view of its devices, or a bay in a failed state
+type Finding struct {
+	Kind      string
+	Enclosure *Enclosure       // nil if the device was not found in an enclosure
+	Slot      int              // -1 if the device has no bay
+	Device    *MultiPathDevice // nil if no OS device was found for the bay
+	Status    string           // SES status of the bay, if any
+}
+
+func (f Finding) String() string {
+	var where []string
+	if f.Enclosure != nil {
+		where = append(where, "Enclosure: "+f.Enclosure.Name())
+	}
+	if f.Slot >= 0 {
+		where = append(where, fmt.Sprintf("Slot: %d", f.Slot))
+	}
+	if f.Device != nil {
+		where = append(where, "Serial: "+f.Device.Serial())
+	}
+	if f.Status != "" {
+		where = append(where, "Status: "+f.Status)
+	}
+	return fmt.Sprintf("%s: %s", f.Kind, strings.Join(where, ", "))
+}
+
+// Reconcile compares the SES device slot status of each enclosure with the
+// disks found in sysfs. Disks only count as missing a bay if they are behind
+// an expander, as directly attached disks have no bay to report.
+func Reconcile(devices map[string]*Device, enclosures map[*Enclosure]bool) []Finding {
+	var findings []Finding
+
+	for enclosure := range enclosures {
+		for _, el := range enclosure.SlotElements() {
+			mpd := enclosure.Slots[el.Slot]
+			switch {
+			case mpd == nil && el.Installed():
+				findings = append(findings, Finding{Kind: FindingNoDevice, Enclosure: enclosure, Slot: el.Slot, Status: el.StatusString()})
+			case mpd != nil && !el.Installed():
+				findings = append(findings, Finding{Kind: FindingNotInstalled, Enclosure: enclosure, Slot: el.Slot, Device: mpd, Status: el.StatusString()})
+			}
+			if el.Fault || el.PrdFail || el.Status == SesStatusCritical || el.Status == SesStatusUnrecoverable {
+				findings = append(findings, Finding{Kind: FindingBayFault, Enclosure: enclosure, Slot: el.Slot, Device: mpd, Status: el.StatusString()})
+			}
+		}
+
+		// Slots found in sysfs that SES knows nothing about
+		if len(enclosure.SlotElements()) == 0 {
+			continue
+		}
+		for slot, mpd := range enclosure.Slots {
+			if enclosure.SlotElement(slot) == nil {
+				findings = append(findings, Finding{Kind: FindingNoBay, Enclosure: enclosure, Slot: slot, Device: mpd})
+			}
+		}
+	}
+
+	reported := map[*MultiPathDevice]bool{}
+	for _, device := range devices {
+		if device.Type != 0 || device.SlotSource != "" || reported[device.MultiPath] {
+			continue
+		}
+		if device.Enclosure == nil && len(device.expanders()) == 0 {
+			continue
+		}
+		reported[device.MultiPath] = true
+		findings = append(findings, Finding{Kind: FindingNoBay, Enclosure: device.Enclosure, Slot: -1, Device: device.MultiPath})
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].String() < findings[j].String()
+	})
+	return findings
+}
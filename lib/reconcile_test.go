@@ -0,0 +1,44 @@
+package sastopo
+
+import "testing"
+
+func TestReconcile(t *testing.T) {
+	encl := &Enclosure{
+		MultiPathDevice: &MultiPathDevice{Paths: map[*Device]bool{}},
+		Elements: []*SesElement{
+			{Type: SesTypeArrayDeviceSlot, Slot: 0, Status: SesStatusOK},
+			{Type: SesTypeArrayDeviceSlot, Slot: 1, Status: SesStatusOK},
+			{Type: SesTypeArrayDeviceSlot, Slot: 2, Status: SesStatusNotInstalled},
+			{Type: SesTypeArrayDeviceSlot, Slot: 3, Status: SesStatusOK, PrdFail: true},
+		},
+	}
+
+	disk := func(id string, slot int, source string) *Device {
+		d := &Device{ID: id, Serial: "SN" + id, Enclosure: encl, Slot: slot, SlotSource: source}
+		d.MultiPath = &MultiPathDevice{Paths: map[*Device]bool{d: true}}
+		return d
+	}
+	devices := map[string]*Device{
+		"0": disk("0", 0, "ses"),
+		"2": disk("2", 2, "bay_identifier"),
+		"3": disk("3", 3, "ses"),
+		"4": disk("4", 0, ""),
+	}
+	encl.Slots = map[int]*MultiPathDevice{0: devices["0"].MultiPath, 2: devices["2"].MultiPath, 3: devices["3"].MultiPath}
+
+	kinds := map[string]int{}
+	for _, f := range Reconcile(devices, map[*Enclosure]bool{encl: true}) {
+		kinds[f.Kind]++
+	}
+	want := map[string]int{
+		FindingNoDevice:     1, // slot 1
+		FindingNotInstalled: 1, // slot 2
+		FindingBayFault:     1, // slot 3
+		FindingNoBay:        1, // device 4
+	}
+	for kind, n := range want {
+		if kinds[kind] != n {
+			t.Errorf("expected %d %q findings, found %d", n, kind, kinds[kind])
+		}
+	}
+}
@@ -0,0 +1,632 @@
+package sastopo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// SES diagnostic page codes
+const (
+	sesPageConfiguration = 0x01
+	sesPageStatus        = 0x02
+	sesPageElementDesc   = 0x07
+	sesPageAdditional    = 0x0a
+)
+
+// SES element type codes
+const (
+	SesTypeDeviceSlot      = 0x01
+	SesTypeESM             = 0x07
+	SesTypeSCSITargetPort  = 0x14
+	SesTypeSCSIInitPort    = 0x15
+	SesTypeArrayDeviceSlot = 0x17
+	SesTypeSASExpander     = 0x18
+)
+
+// SES element status codes
+const (
+	SesStatusUnsupported   = 0x0
+	SesStatusOK            = 0x1
+	SesStatusCritical      = 0x2
+	SesStatusNoncritical   = 0x3
+	SesStatusUnrecoverable = 0x4
+	SesStatusNotInstalled  = 0x5
+	SesStatusUnknown       = 0x6
+	SesStatusNotAvailable  = 0x7
+	SesStatusNoAccess      = 0x8
+)
+
+var sesStatusNames = map[int]string{
+	SesStatusUnsupported:   "Unsupported",
+	SesStatusOK:            "OK",
+	SesStatusCritical:      "Critical",
+	SesStatusNoncritical:   "Noncritical",
+	SesStatusUnrecoverable: "Unrecoverable",
+	SesStatusNotInstalled:  "Not Installed",
+	SesStatusUnknown:       "Unknown",
+	SesStatusNotAvailable:  "Not Available",
+	SesStatusNoAccess:      "No Access",
+}
+
+// sesProtocolSAS is the SAS protocol identifier used in page 0xA
+const sesProtocolSAS = 0x6
+
+// ErrShortSesPage is when a SES diagnostic page is shorter than its headers claim
+var ErrShortSesPage = errors.New("SES page truncated")
+
+// SesElement is an individual element of an enclosure as reported by SES
+type SesElement struct {
+	Type         int      // Element type code
+	Index        int      // Element index not counting overall elements, as used by sg_ses --index
+	TypeIndex    int      // Position among the elements of its type descriptor header
+	SubEnclosure int      // Subenclosure identifier
+	Slot         int      // Slot number as used by Device.Slot, device slot elements only
+	SlotIndex    int      // Position among the device slot elements, from 0
+	SlotSource   string   // Where Slot was found: index, device or additional (page 0xA)
+	Descriptor   string   // Element descriptor text from page 0x7
+	Drawer       string   // Drawer the element is in, from its descriptor or subenclosure
+	SasAddresses []string // SAS addresses of attached devices, or of the expander or ESM itself, from page 0xA
+	Status       int      // Element status code from page 0x2
+	PrdFail      bool     // Predicted failure
+	Ident        bool     // Identify (locate) indicator is on, device slots only
+	Fault        bool     // Fault sensed or requested, device slots only
+	DeviceOff    bool     // Device is powered off, device slots only
+	OverallIndex int      // Element index counting overall elements
+	TypeHeader   int      // Index of the element's type descriptor header
+}
+
+// SesSubEnclosure is a subenclosure described by an enclosure descriptor of
+// the SES configuration page
+type SesSubEnclosure struct {
+	ID        int    // Subenclosure identifier, 0 is the primary subenclosure
+	LogicalID string // Enclosure logical identifier, hex
+	Vendor    string
+	Product   string
+	Rev       string
+}
+
+// IsSlot returns true if the element is a Device Slot or Array Device Slot element
+func (el *SesElement) IsSlot() bool {
+	return el.Type == SesTypeDeviceSlot || el.Type == SesTypeArrayDeviceSlot
+}
+
+// Installed returns true unless SES reports the element as not installed
+func (el *SesElement) Installed() bool {
+	return el.Status != SesStatusNotInstalled
+}
+
+// StatusString returns the element status name followed by any fault,
+// predicted failure, ident or device off indications
+func (el *SesElement) StatusString() string {
+	status, ok := sesStatusNames[el.Status]
+	if !ok {
+		status = fmt.Sprintf("Reserved (0x%x)", el.Status)
+	}
+	flags := []string{status}
+	if el.Fault {
+		flags = append(flags, "Fault")
+	}
+	if el.PrdFail {
+		flags = append(flags, "Predicted Failure")
+	}
+	if el.Ident {
+		flags = append(flags, "Ident")
+	}
+	if el.DeviceOff {
+		flags = append(flags, "Device Off")
+	}
+	return strings.Join(flags, ", ")
+}
+
+// hasAdditional returns true if the element type may have an additional
+// element status descriptor on page 0xA
+func (el *SesElement) hasAdditional() bool {
+	switch el.Type {
+	case SesTypeDeviceSlot, SesTypeArrayDeviceSlot, SesTypeSASExpander,
+		SesTypeSCSIInitPort, SesTypeSCSITargetPort, SesTypeESM:
+		return true
+	}
+	return false
+}
+
+// sesTypeHeader is a type descriptor header from the SES configuration page
+type sesTypeHeader struct {
+	Type         int
+	Elements     int
+	SubEnclosure int
+	Text         string
+	textLen      int
+}
+
+// sgSesPage runs "sg_ses --raw" against an enclosure's SCSI generic device
+// and returns the decoded diagnostic page.
+// This function requires root privledges and sg3_utils to be installed.
+func sgSesPage(sg string, page int) ([]byte, error) {
+	if _, err := os.Stat("/dev/" + sg); err != nil {
+		return nil, err
+	}
+
+	args := []string{fmt.Sprintf("--page=0x%x", page), "--raw", "/dev/" + sg}
+	cmdOut, err := exec.Command("sg_ses", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("running sg_ses page 0x%x on %s failed: %s", page, sg, err)
+	}
+	data, n, err := sgSesToBytes(cmdOut)
+	if err != nil {
+		return nil, fmt.Errorf("decoding sg_ses page 0x%x on %s failed: %s", page, sg, err)
+	}
+	if n < 8 {
+		return nil, ErrShortSesPage
+	}
+	return data[:n], nil
+}
+
+// parseSesConfig parses the SES configuration page (0x1) and returns its
+// subenclosures and type descriptor headers in page order.
+func parseSesConfig(page []byte) ([]SesSubEnclosure, []sesTypeHeader, error) {
+	if len(page) < 8 || page[0] != sesPageConfiguration {
+		return nil, nil, ErrShortSesPage
+	}
+
+	// Walk the enclosure descriptors, one for the primary subenclosure
+	// plus one for each secondary subenclosure.
+	off := 8
+	count := 0
+	var subs []SesSubEnclosure
+	for i := 0; i <= int(page[1]); i++ {
+		if off+4 > len(page) {
+			return nil, nil, ErrShortSesPage
+		}
+		length := int(page[off+3]) + 4
+		if off+length > len(page) {
+			return nil, nil, ErrShortSesPage
+		}
+		sub := SesSubEnclosure{ID: int(page[off+1])}
+		if length >= 40 {
+			sub.LogicalID = fmt.Sprintf("%x", page[off+4:off+12])
+			sub.Vendor = trimString(page[off+12 : off+20])
+			sub.Product = trimString(page[off+20 : off+36])
+			sub.Rev = trimString(page[off+36 : off+40])
+		}
+		subs = append(subs, sub)
+		count += int(page[off+2])
+		off += length
+	}
+
+	headers := make([]sesTypeHeader, count)
+	for i := range headers {
+		if off+4 > len(page) {
+			return nil, nil, ErrShortSesPage
+		}
+		headers[i] = sesTypeHeader{
+			Type:         int(page[off]),
+			Elements:     int(page[off+1]),
+			SubEnclosure: int(page[off+2]),
+			textLen:      int(page[off+3]),
+		}
+		off += 4
+	}
+
+	// Type descriptor text follows the headers, a zero length is allowed
+	for i := range headers {
+		l := headers[i].textLen
+		if off+l > len(page) {
+			return nil, nil, ErrShortSesPage
+		}
+		headers[i].Text = trimString(page[off : off+l])
+		off += l
+	}
+
+	return subs, headers, nil
+}
+
+// sesElements returns the individual elements described by the type
+// descriptor headers. Slots are indexed, and until numbered by numberSlots
+// also numbered, in order of appearance.
+func sesElements(headers []sesTypeHeader) []*SesElement {
+	var (
+		elements []*SesElement
+		slot     int
+		overall  int
+	)
+	for n, h := range headers {
+		overall++
+		for i := 0; i < h.Elements; i++ {
+			el := &SesElement{
+				Type:         h.Type,
+				Index:        len(elements),
+				TypeIndex:    i,
+				SubEnclosure: h.SubEnclosure,
+				OverallIndex: overall,
+				TypeHeader:   n,
+			}
+			overall++
+			if el.IsSlot() {
+				el.Slot, el.SlotIndex, el.SlotSource = slot, slot, "index"
+				slot++
+			}
+			elements = append(elements, el)
+		}
+	}
+	return elements
+}
+
+// parseSesDescriptors updates the Descriptor of each element from the SES
+// element descriptor page (0x7). Each type descriptor header has an overall
+// descriptor followed by one descriptor per individual element.
+func parseSesDescriptors(page []byte, headers []sesTypeHeader, elements []*SesElement) error {
+	if len(page) < 8 || page[0] != sesPageElementDesc {
+		return ErrShortSesPage
+	}
+
+	off := 8
+	next := func() ([]byte, error) {
+		if off+4 > len(page) {
+			return nil, ErrShortSesPage
+		}
+		l := int(binary.BigEndian.Uint16(page[off+2 : off+4]))
+		if off+4+l > len(page) {
+			return nil, ErrShortSesPage
+		}
+		desc := page[off+4 : off+4+l]
+		off += 4 + l
+		return desc, nil
+	}
+
+	index := 0
+	for _, h := range headers {
+		// Overall descriptor
+		if _, err := next(); err != nil {
+			return err
+		}
+		for i := 0; i < h.Elements; i++ {
+			desc, err := next()
+			if err != nil {
+				return err
+			}
+			if index < len(elements) {
+				start, stop := trimPoints(desc)
+				elements[index].Descriptor = string(desc[start:stop])
+			}
+			index++
+		}
+	}
+	return nil
+}
+
+// parseSesStatus updates the status of each element from the SES enclosure
+// status page (0x2). Each type descriptor header has an overall status
+// element followed by one 4 byte status element per individual element.
+func parseSesStatus(page []byte, headers []sesTypeHeader, elements []*SesElement) error {
+	if len(page) < 8 || page[0] != sesPageStatus {
+		return ErrShortSesPage
+	}
+
+	off := 8
+	index := 0
+	for _, h := range headers {
+		// Skip the overall status element
+		off += 4
+		for i := 0; i < h.Elements; i++ {
+			if off+4 > len(page) {
+				return ErrShortSesPage
+			}
+			status := page[off : off+4]
+			off += 4
+			if index >= len(elements) {
+				continue
+			}
+			el := elements[index]
+			index++
+
+			el.Status = int(status[0] & 0x0f)
+			el.PrdFail = status[0]&0x40 != 0
+			if el.IsSlot() {
+				el.Ident = status[2]&0x02 != 0
+				el.Fault = status[3]&0x60 != 0
+				el.DeviceOff = status[3]&0x10 != 0
+			}
+		}
+	}
+	return nil
+}
+
+// parseSesAdditional updates the Slot and SasAddresses of device slot
+// elements, and the SasAddresses of SAS expander, ESM and port elements,
+// from the SES additional element status page (0xA). Descriptors without an
+// element index are matched in order to the element types which may have
+// additional element status.
+func parseSesAdditional(page []byte, elements []*SesElement) error {
+	if len(page) < 8 || page[0] != sesPageAdditional {
+		return ErrShortSesPage
+	}
+
+	var ordered []*SesElement
+	for _, el := range elements {
+		if el.hasAdditional() {
+			ordered = append(ordered, el)
+		}
+	}
+
+	off := 8
+	for n := 0; off+2 <= len(page); n++ {
+		eip := page[off]&0x10 != 0
+		invalid := page[off]&0x80 != 0
+		protocol := page[off] & 0x0f
+		l := int(page[off+1]) + 2
+		if off+l > len(page) {
+			return ErrShortSesPage
+		}
+		desc := page[off : off+l]
+		off += l
+
+		var (
+			el   *SesElement
+			body []byte
+		)
+		if eip {
+			if len(desc) < 4 {
+				return ErrShortSesPage
+			}
+			index := int(desc[3])
+			for _, e := range elements {
+				// EIIOE of 1 means the element index includes overall elements
+				if (desc[2]&0x3 == 1 && e.OverallIndex == index) || (desc[2]&0x3 != 1 && e.Index == index) {
+					el = e
+					break
+				}
+			}
+			body = desc[4:]
+		} else {
+			if n < len(ordered) {
+				el = ordered[n]
+			}
+			body = desc[2:]
+		}
+		if el == nil || invalid || protocol != sesProtocolSAS || len(body) < 2 {
+			continue
+		}
+
+		switch {
+		case el.IsSlot():
+			// SAS device slot descriptors have a 4 byte header with the
+			// device slot number when EIP is set, otherwise a 2 byte header,
+			// then 28 byte phy descriptors
+			if body[1]>>6 != 0 {
+				continue
+			}
+			header := 2
+			if eip {
+				if len(body) < 4 {
+					continue
+				}
+				header = 4
+				el.Slot, el.SlotSource = int(body[3]), "additional"
+			}
+			el.SasAddresses = nil
+			for i := 0; i < int(body[0]); i++ {
+				phy := body[header+28*i:]
+				if len(phy) < 28 {
+					return ErrShortSesPage
+				}
+				addr := binary.BigEndian.Uint64(phy[12:20])
+				if addr != 0 {
+					el.SasAddresses = append(el.SasAddresses, fmt.Sprintf("0x%016x", addr))
+				}
+			}
+		case el.Type == SesTypeSASExpander:
+			// SAS expander descriptors have the expander's SAS address after
+			// a 4 byte header
+			if body[1]>>6 != 1 || len(body) < 12 {
+				continue
+			}
+			el.SasAddresses = []string{fmt.Sprintf("0x%016x", binary.BigEndian.Uint64(body[4:12]))}
+		case el.Type == SesTypeESM, el.Type == SesTypeSCSITargetPort, el.Type == SesTypeSCSIInitPort:
+			// Port and ESM descriptors share the expander's descriptor type,
+			// with a 4 byte header then 12 byte phy descriptors
+			if body[1]>>6 != 1 {
+				continue
+			}
+			el.SasAddresses = nil
+			for i := 0; i < int(body[0]); i++ {
+				phy := body[4+12*i:]
+				if len(phy) < 12 {
+					return ErrShortSesPage
+				}
+				addr := binary.BigEndian.Uint64(phy[4:12])
+				if addr != 0 && !containsString(el.SasAddresses, fmt.Sprintf("0x%016x", addr)) {
+					el.SasAddresses = append(el.SasAddresses, fmt.Sprintf("0x%016x", addr))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// updateSes reads the SES configuration, element descriptor, enclosure status
+// and additional element status pages from the enclosure and updates Elements
+func (e *Enclosure) updateSes() error {
+	sg := e.sg()
+	if sg == "" {
+		return fmt.Errorf("no SCSI generic device found for enclosure %s", e.Serial())
+	}
+
+	page, err := sgSesPage(sg, sesPageConfiguration)
+	if err != nil {
+		return err
+	}
+	subs, headers, err := parseSesConfig(page)
+	if err != nil {
+		return err
+	}
+	e.SubEnclosures = subs
+	e.Elements = sesElements(headers)
+
+	page, err = sgSesPage(sg, sesPageElementDesc)
+	if err != nil {
+		return err
+	}
+	if err := parseSesDescriptors(page, headers, e.Elements); err != nil {
+		return err
+	}
+
+	page, err = sgSesPage(sg, sesPageStatus)
+	if err != nil {
+		return err
+	}
+	if err := parseSesStatus(page, headers, e.Elements); err != nil {
+		return err
+	}
+
+	// Not every enclosure supports page 0xA, fall back on sysfs for slots
+	page, err = sgSesPage(sg, sesPageAdditional)
+	if err != nil {
+		warning("enclosure %s: %s", e.Serial(), err)
+		return nil
+	}
+	return parseSesAdditional(page, e.Elements)
+}
+
+// componentElement returns the device slot element of the enclosure
+// component a device is linked to. Linux names components by their element
+// descriptor, or by their index among the slot elements if it is empty.
+func (e *Enclosure) componentElement(component string) *SesElement {
+	if component == "" {
+		return nil
+	}
+	for _, el := range e.Elements {
+		if el.IsSlot() && (el.Descriptor == component || (el.Descriptor == "" && strconv.Itoa(el.SlotIndex) == component)) {
+			return el
+		}
+	}
+	return nil
+}
+
+// slotElementAttached returns the device slot element SES reports the SAS
+// address attached to
+func (e *Enclosure) slotElementAttached(addr string) *SesElement {
+	for _, el := range e.Elements {
+		if !el.IsSlot() {
+			continue
+		}
+		for _, a := range el.SasAddresses {
+			if a == addr {
+				return el
+			}
+		}
+	}
+	return nil
+}
+
+// numberSlots numbers the device slot elements like Device.Slot. SES counts
+// slot elements from 0, while bay_identifier often counts bays from 1. An
+// element without a device slot number from page 0xA takes the slot of a
+// device linked to it, by the device's enclosure_device component or SAS
+// address, and the others are offset from their SlotIndex by as much as
+// every numbered element agrees on.
+func (e *Enclosure) numberSlots(devices map[string]*Device) {
+	linked := map[*SesElement]*Device{}
+	for _, device := range devices {
+		if device.Enclosure != e || device.SlotSource == "" {
+			continue
+		}
+		el := e.componentElement(device.component)
+		if el == nil && device.SasAddress != "" {
+			el = e.slotElementAttached(device.SasAddress)
+		}
+		if el != nil && el.SlotSource != "additional" {
+			linked[el] = device
+		}
+	}
+
+	offset, offsets := 0, map[int]bool{}
+	for _, el := range e.Elements {
+		if device, ok := linked[el]; ok {
+			el.Slot, el.SlotSource = device.Slot, "device"
+		}
+		if el.IsSlot() && el.SlotSource != "index" {
+			offset = el.Slot - el.SlotIndex
+			offsets[offset] = true
+		}
+	}
+	if len(offsets) > 1 {
+		diagnostic("enclosure %s: SES slot elements and devices disagree on the slot numbering", e.Serial())
+		return
+	}
+	for _, el := range e.Elements {
+		if el.IsSlot() && el.SlotSource == "index" {
+			el.Slot = el.SlotIndex + offset
+		}
+	}
+}
+
+// updateSlotsFromSes assigns the slot of each device attached to one of the
+// enclosure's device slot elements, numbered by numberSlots. SES is
+// authoritative, if sysfs placed the device elsewhere a diagnostic is
+// recorded.
+func (e *Enclosure) updateSlotsFromSes(devicesBySASAddress map[string]map[*Device]bool) {
+	for _, el := range e.Elements {
+		if !el.IsSlot() {
+			continue
+		}
+		for _, addr := range el.SasAddresses {
+			for device := range devicesBySASAddress[addr] {
+				if device.Enclosure == nil {
+					diagnostic("%s (%s): SES places device in enclosure %s slot %d, sysfs found no enclosure",
+						device.ID, device.Serial, e.Serial(), el.Slot)
+					device.Enclosure = e
+				} else if device.Enclosure != e {
+					diagnostic("%s (%s): SES places device in enclosure %s slot %d, sysfs found enclosure %s",
+						device.ID, device.Serial, e.Serial(), el.Slot, device.Enclosure.Serial())
+					device.Enclosure = e
+				} else if device.SlotSource != "" && device.Slot != el.Slot {
+					diagnostic("%s (%s): %s slot %d disagrees with SES slot %d in enclosure %s",
+						device.ID, device.Serial, device.SlotSource, device.Slot, el.Slot, e.Serial())
+				} else if device.SlotSource != "" {
+					continue
+				}
+				device.Slot = el.Slot
+				device.SlotSource = "ses"
+			}
+		}
+	}
+}
+
+// updateEnclosureSes reads SES pages from every enclosure, numbers their
+// slot elements, groups their elements into drawers and assigns slots to the
+// devices SES reports as attached
+func updateEnclosureSes(enclosures map[*Enclosure]bool, devices map[string]*Device, devicesBySASAddress map[string]map[*Device]bool) {
+	for enclosure := range enclosures {
+		if err := enclosure.updateSes(); err != nil {
+			warning("%s", err)
+			continue
+		}
+		enclosure.numberSlots(devices)
+		enclosure.updateDrawers()
+		enclosure.updateSlotsFromSes(devicesBySASAddress)
+	}
+}
+
+// updateSlotLabels labels each device with its slot's element descriptor
+// and drawer
+func updateSlotLabels(enclosures map[*Enclosure]bool) {
+	for enclosure := range enclosures {
+		for slot, mpd := range enclosure.Slots {
+			el := enclosure.SlotElement(slot)
+			if el == nil {
+				continue
+			}
+			for device := range mpd.Paths {
+				if el.Descriptor != "" {
+					device.SlotLabel = el.Descriptor
+				}
+				device.Drawer = el.Drawer
+			}
+		}
+	}
+}
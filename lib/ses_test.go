@@ -0,0 +1,282 @@
+package sastopo
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// testHeaders are the type descriptor headers used to build test pages:
+// 3 array device slots, then 2 power supplies
+var testHeaders = []sesTypeHeader{
+	{Type: SesTypeArrayDeviceSlot, Elements: 3, Text: "Drive Slots"},
+	{Type: 0x02, Elements: 2, Text: "PSU"},
+}
+
+func testPage(code byte, body []byte) []byte {
+	page := make([]byte, 8, 8+len(body))
+	page[0] = code
+	binary.BigEndian.PutUint16(page[2:4], uint16(len(body)+4))
+	return append(page, body...)
+}
+
+func testConfigPage(headers []sesTypeHeader) []byte {
+	// Single primary subenclosure descriptor with a 36 byte body
+	desc := make([]byte, 40)
+	desc[2] = byte(len(headers))
+	desc[3] = 36
+	copy(desc[4:], []byte{0x50, 0x00, 0xcc, 0xab, 0x04, 0x00, 0x00, 0x00})
+	copy(desc[12:], "VENDOR  ")
+	copy(desc[20:], "JBOD60          ")
+
+	body := desc
+	for _, h := range headers {
+		body = append(body, byte(h.Type), byte(h.Elements), byte(h.SubEnclosure), byte(len(h.Text)))
+	}
+	for _, h := range headers {
+		body = append(body, h.Text...)
+	}
+	return testPage(sesPageConfiguration, body)
+}
+
+func testDescriptorPage(descs [][]string) []byte {
+	var body []byte
+	for _, d := range descs {
+		for _, text := range d {
+			body = append(body, 0, 0, 0, byte(len(text)))
+			body = append(body, text...)
+		}
+	}
+	return testPage(sesPageElementDesc, body)
+}
+
+func TestParseSesConfig(t *testing.T) {
+	subs, headers, err := parseSesConfig(testConfigPage(testHeaders))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 1 || subs[0].LogicalID != "5000ccab04000000" || subs[0].Vendor != "VENDOR" || subs[0].Product != "JBOD60" {
+		t.Errorf("unexpected subenclosures: %#v", subs)
+	}
+	if len(headers) != 2 {
+		t.Fatalf("expected 2 type descriptor headers, found %d", len(headers))
+	}
+	if headers[0].Type != SesTypeArrayDeviceSlot || headers[0].Elements != 3 || headers[0].Text != "Drive Slots" {
+		t.Errorf("unexpected first header: %#v", headers[0])
+	}
+	if headers[1].Text != "PSU" {
+		t.Errorf("unexpected second header text: %q", headers[1].Text)
+	}
+
+	if _, _, err := parseSesConfig(testConfigPage(testHeaders)[:50]); err != ErrShortSesPage {
+		t.Errorf("expected ErrShortSesPage on truncated page, got %v", err)
+	}
+}
+
+func TestParseSesDescriptors(t *testing.T) {
+	elements := sesElements(testHeaders)
+	if len(elements) != 5 {
+		t.Fatalf("expected 5 elements, found %d", len(elements))
+	}
+
+	page := testDescriptorPage([][]string{
+		{"Drives", "SLOT 01", "Disk Drawer 2 Slot 14 ", "SLOT 03\x00"},
+		{"", "PSU A", "PSU B"},
+	})
+	if err := parseSesDescriptors(page, testHeaders, elements); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"SLOT 01", "Disk Drawer 2 Slot 14", "SLOT 03", "PSU A", "PSU B"}
+	for i, el := range elements {
+		if el.Descriptor != want[i] {
+			t.Errorf("element %d: expected descriptor %q, found %q", i, want[i], el.Descriptor)
+		}
+	}
+
+	encl := &Enclosure{Elements: elements}
+	if el := encl.SlotElement(1); el == nil || el.Descriptor != "Disk Drawer 2 Slot 14" {
+		t.Errorf("unexpected element for slot 1: %#v", el)
+	}
+	if el := encl.SlotElement(3); el != nil {
+		t.Errorf("expected no element for slot 3, found %#v", el)
+	}
+}
+
+func TestNumberSlots(t *testing.T) {
+	// bay_identifier counts from 1, SES elements from 0
+	elements := sesElements(testHeaders)
+	for i, text := range []string{"SLOT 01", "SLOT 02", "SLOT 03"} {
+		elements[i].Descriptor = text
+	}
+	encl := &Enclosure{Elements: elements}
+	device := &Device{ID: "1:0:2:0", Enclosure: encl, Slot: 2, SlotSource: "bay_identifier", component: "SLOT 02"}
+	devices := map[string]*Device{device.ID: device}
+	encl.numberSlots(devices)
+
+	for i, want := range []int{1, 2, 3} {
+		if elements[i].Slot != want || elements[i].SlotIndex != i {
+			t.Errorf("element %d: expected slot %d index %d, found %d index %d", i, want, i, elements[i].Slot, elements[i].SlotIndex)
+		}
+	}
+	if el := encl.SlotElement(2); el == nil || el.Descriptor != "SLOT 02" || el.SlotSource != "device" {
+		t.Errorf("unexpected element for slot 2: %#v", el)
+	}
+	if el := encl.SlotElement(0); el != nil {
+		t.Errorf("expected no element for slot 0, found %#v", el)
+	}
+
+	// Components without a descriptor are named by their index
+	elements = sesElements(testHeaders)
+	encl = &Enclosure{Elements: elements}
+	device.Enclosure, device.component = encl, "1"
+	encl.numberSlots(devices)
+	if el := encl.SlotElement(3); el == nil || el.SlotIndex != 2 {
+		t.Errorf("unexpected element for slot 3: %#v", el)
+	}
+}
+
+func testAdditionalSlot(index int, slot int, addrs ...uint64) []byte {
+	desc := []byte{0x10 | sesProtocolSAS, 0, 0, byte(index), byte(len(addrs)), 0, 0, byte(slot)}
+	for _, addr := range addrs {
+		phy := make([]byte, 28)
+		binary.BigEndian.PutUint64(phy[4:12], 0x500a0b8000000000)
+		binary.BigEndian.PutUint64(phy[12:20], addr)
+		desc = append(desc, phy...)
+	}
+	desc[1] = byte(len(desc) - 2)
+	return desc
+}
+
+func TestParseSesAdditional(t *testing.T) {
+	elements := sesElements(testHeaders)
+
+	var body []byte
+	body = append(body, testAdditionalSlot(0, 1, 0x5000c500a0000001, 0x5000c500a0000002)...)
+	body = append(body, testAdditionalSlot(1, 2)...)
+	body = append(body, testAdditionalSlot(2, 3, 0x5000c500a0000003)...)
+	if err := parseSesAdditional(testPage(sesPageAdditional, body), elements); err != nil {
+		t.Fatal(err)
+	}
+
+	if elements[0].Slot != 1 || len(elements[0].SasAddresses) != 2 || elements[0].SasAddresses[1] != "0x5000c500a0000002" {
+		t.Errorf("unexpected first slot element: %#v", elements[0])
+	}
+	if elements[1].Slot != 2 || len(elements[1].SasAddresses) != 0 {
+		t.Errorf("unexpected empty slot element: %#v", elements[1])
+	}
+
+	expander := &SesElement{Type: SesTypeSASExpander, Index: 5}
+	esm := &SesElement{Type: SesTypeESM, Index: 6}
+	desc := []byte{0x10 | sesProtocolSAS, 0, 0, 5, 0, 0x40, 0, 0}
+	desc = append(desc, 0x50, 0x0a, 0x0b, 0x80, 0, 0, 0, 0x10)
+	desc[1] = byte(len(desc) - 2)
+	body = desc
+	desc = []byte{0x10 | sesProtocolSAS, 0, 0, 6, 2, 0x40, 0, 0}
+	for _, addr := range []uint64{0x500a0b8000000010, 0x500a0b8000000010} {
+		phy := make([]byte, 12)
+		binary.BigEndian.PutUint64(phy[4:12], addr)
+		desc = append(desc, phy...)
+	}
+	desc[1] = byte(len(desc) - 2)
+	body = append(body, desc...)
+	if err := parseSesAdditional(testPage(sesPageAdditional, body), []*SesElement{expander, esm}); err != nil {
+		t.Fatal(err)
+	}
+	if len(expander.SasAddresses) != 1 || expander.SasAddresses[0] != "0x500a0b8000000010" {
+		t.Errorf("unexpected expander SAS addresses: %v", expander.SasAddresses)
+	}
+	if len(esm.SasAddresses) != 1 || esm.SasAddresses[0] != "0x500a0b8000000010" {
+		t.Errorf("unexpected ESM SAS addresses: %v", esm.SasAddresses)
+	}
+
+	encl := &Enclosure{MultiPathDevice: &MultiPathDevice{Paths: map[*Device]bool{}}, Elements: elements}
+	device := &Device{ID: "1:0:3:0", SasAddress: "0x5000c500a0000003", Enclosure: encl, Slot: 7, SlotSource: "bay_identifier"}
+	resetDiagnostics()
+	encl.updateSlotsFromSes(map[string]map[*Device]bool{device.SasAddress: {device: true}})
+	if device.Slot != 3 || device.SlotSource != "ses" {
+		t.Errorf("expected SES slot 3, found %d from %s", device.Slot, device.SlotSource)
+	}
+	if len(Diagnostics()) != 1 {
+		t.Errorf("expected a diagnostic for the slot disagreement, found %v", Diagnostics())
+	}
+
+	// Without EIP, descriptors are in element order, phys follow a 2 byte
+	// header and there is no device slot number, so the slot elements are
+	// numbered from the 1-based bay_identifier of an attached device
+	noEIP := sesElements(testHeaders)
+	body = nil
+	for _, addrs := range [][]uint64{{0x5000c500a0000011}, nil, {0x5000c500a0000013}} {
+		desc := []byte{sesProtocolSAS, 0, byte(len(addrs)), 0}
+		for _, addr := range addrs {
+			phy := make([]byte, 28)
+			binary.BigEndian.PutUint64(phy[12:20], addr)
+			desc = append(desc, phy...)
+		}
+		desc[1] = byte(len(desc) - 2)
+		body = append(body, desc...)
+	}
+	if err := parseSesAdditional(testPage(sesPageAdditional, body), noEIP); err != nil {
+		t.Fatal(err)
+	}
+	if len(noEIP[0].SasAddresses) != 1 || noEIP[0].SasAddresses[0] != "0x5000c500a0000011" ||
+		len(noEIP[1].SasAddresses) != 0 || len(noEIP[2].SasAddresses) != 1 || noEIP[2].SasAddresses[0] != "0x5000c500a0000013" {
+		t.Errorf("unexpected SAS addresses without EIP: %v %v %v", noEIP[0].SasAddresses, noEIP[1].SasAddresses, noEIP[2].SasAddresses)
+	}
+
+	encl = &Enclosure{MultiPathDevice: &MultiPathDevice{Paths: map[*Device]bool{}}, Elements: noEIP}
+	device = &Device{ID: "1:0:3:0", SasAddress: "0x5000c500a0000013", Enclosure: encl, Slot: 3, SlotSource: "bay_identifier"}
+	devices := map[string]*Device{device.ID: device}
+	resetDiagnostics()
+	encl.numberSlots(devices)
+	encl.updateSlotsFromSes(map[string]map[*Device]bool{device.SasAddress: {device: true}})
+	if device.Slot != 3 || device.SlotSource != "bay_identifier" || len(Diagnostics()) != 0 {
+		t.Errorf("expected bay_identifier slot 3 kept, found %d from %s, diagnostics %v", device.Slot, device.SlotSource, Diagnostics())
+	}
+	for i, want := range []int{1, 2, 3} {
+		if noEIP[i].Slot != want {
+			t.Errorf("element %d: expected slot %d, found %d", i, want, noEIP[i].Slot)
+		}
+	}
+	if empty := encl.SlotElement(2); empty == nil || len(empty.SasAddresses) != 0 {
+		t.Errorf("expected the empty bay as slot 2, found %#v", empty)
+	}
+}
+
+func TestParseSesStatus(t *testing.T) {
+	elements := sesElements(testHeaders)
+
+	page := testPage(sesPageStatus, []byte{
+		0, 0, 0, 0, // overall device slot
+		0x01, 0, 0, 0, // slot 0: OK
+		0x05, 0, 0, 0, // slot 1: not installed
+		0x42, 0, 0x02, 0x40, // slot 2: critical, predicted failure, ident, fault sensed
+		0, 0, 0, 0, // overall power supply
+		0x01, 0, 0, 0,
+		0x02, 0, 0, 0,
+	})
+	if err := parseSesStatus(page, testHeaders, elements); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := elements[2].StatusString(); got != "Critical, Fault, Predicted Failure, Ident" {
+		t.Errorf("unexpected slot 2 status: %q", got)
+	}
+	if elements[4].Status != SesStatusCritical {
+		t.Errorf("expected critical power supply, found %d", elements[4].Status)
+	}
+
+	encl := &Enclosure{Elements: elements}
+	if encl.TotalSlots() != 3 || encl.PopulatedSlots() != 2 {
+		t.Errorf("expected 2 of 3 slots populated, found %d of %d", encl.PopulatedSlots(), encl.TotalSlots())
+	}
+	if empty := encl.EmptySlots(); len(empty) != 1 || empty[0] != 1 {
+		t.Errorf("expected slot 1 empty, found %v", empty)
+	}
+
+	// Empty bays are numbered like Device.Slot, here counted from 1
+	device := &Device{ID: "1:0:1:0", Enclosure: encl, Slot: 1, SlotSource: "bay_identifier", component: "0"}
+	encl.numberSlots(map[string]*Device{device.ID: device})
+	if empty := encl.EmptySlots(); len(empty) != 1 || empty[0] != 2 {
+		t.Errorf("expected slot 2 empty, found %v", empty)
+	}
+}
@@ -0,0 +1,117 @@
+package sastopo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"syscall"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// sesCacheTTL is how long SES pages cached for LookupBlock are used before
+// the enclosure is read again. Long enough to cover the udev events of every
+// drive at boot, short enough to see a replaced drive.
+const sesCacheTTL = time.Minute
+
+// sesCacheUnsafe matches characters not used in cache file names
+var sesCacheUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// sesCache is the SES data of an enclosure kept between LookupBlock calls
+type sesCache struct {
+	Serial        string
+	SubEnclosures []SesSubEnclosure
+	Elements      []*SesElement
+}
+
+// sesCacheKey identifies the enclosure of an SES device from sysfs alone: by
+// its logical ID from the enclosure class, shared by every path, or else by
+// the SES device's SAS address
+func sesCacheKey(encl *Device) string {
+	if obj, err := encl.sysfsObj.SubObject("enclosure"); err == nil {
+		for _, e := range obj.SubObjects() {
+			if id, err := e.Attribute("id").Read(); err == nil && id != "" {
+				return id
+			}
+		}
+	}
+	if encl.SasAddress != "" {
+		return encl.SasAddress
+	}
+	return encl.ID
+}
+
+// loadSes sets the serial, subenclosures and SES elements of the enclosure
+// from the cache in dir, reading them from the enclosure and saving them
+// when the cache is missing or older than sesCacheTTL. Concurrent callers
+// wait for each other, so the enclosure is read once. An empty dir reads the
+// enclosure without caching.
+func (e *Enclosure) loadSes(dir string) error {
+	var (
+		encl  *Device
+		paths = e.MultiPathDevice.Devices()
+	)
+	for _, path := range paths {
+		if encl == nil || path.ID < encl.ID {
+			encl = path
+		}
+	}
+	if encl == nil {
+		return nil
+	}
+	if dir == "" {
+		return e.readSes(encl)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	file := filepath.Join(dir, "ses-"+sesCacheUnsafe.ReplaceAllString(sesCacheKey(encl), "_")+".yaml")
+	lock, err := os.OpenFile(file+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(file); err == nil && time.Since(info.ModTime()) < sesCacheTTL {
+		if data, err := ioutil.ReadFile(file); err == nil {
+			var c sesCache
+			if err := yaml.Unmarshal(data, &c); err == nil {
+				for _, path := range paths {
+					path.Serial = c.Serial
+				}
+				e.SubEnclosures, e.Elements = c.SubEnclosures, c.Elements
+				return nil
+			}
+		}
+	}
+
+	if err := e.readSes(encl); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(sesCache{Serial: encl.Serial, SubEnclosures: e.SubEnclosures, Elements: e.Elements})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// readSes reads the enclosure's serial through one of its SES devices, then
+// its SES pages
+func (e *Enclosure) readSes(encl *Device) error {
+	if err := encl.updateSerial(); err != nil {
+		warning("%s", err)
+	}
+	for path := range e.MultiPathDevice.Paths {
+		path.Serial = encl.Serial
+	}
+	return e.updateSes()
+}
@@ -0,0 +1,46 @@
+package sastopo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestLoadSesCached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sastopo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cached := sesCache{
+		Serial:        "SHX0969057G0019",
+		SubEnclosures: []SesSubEnclosure{{LogicalID: "5000ccab0400b8bf"}},
+		Elements: []*SesElement{{Type: SesTypeArrayDeviceSlot, Index: 3, TypeIndex: 3, Slot: 3, Descriptor: "Drawer 1 Slot 3",
+			SasAddresses: []string{"0x5000c500a0000003"}, OverallIndex: 4, TypeHeader: 1}},
+	}
+	data, err := yaml.Marshal(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "ses-0x500a0b8000000010.yaml"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh cache is used without reading the enclosure
+	encl := &Device{ID: "1:0:0:0", Type: 13, SasAddress: "0x500a0b8000000010"}
+	e := &Enclosure{MultiPathDevice: &MultiPathDevice{Paths: map[*Device]bool{encl: true}}}
+	if err := e.loadSes(dir); err != nil {
+		t.Fatal(err)
+	}
+	if e.Serial() != cached.Serial || e.LogicalID() != "5000ccab0400b8bf" || len(e.Elements) != 1 || e.Elements[0].Slot != 3 {
+		t.Errorf("unexpected enclosure from cache: %s %s %v", e.Serial(), e.LogicalID(), e.Elements)
+	}
+	// Cached elements can still be addressed by sg_ses
+	if el := e.Elements[0]; el.OverallIndex != 4 || el.TypeHeader != 1 {
+		t.Errorf("expected overall index 4 and type header 1 from cache, found %d and %d", el.OverallIndex, el.TypeHeader)
+	}
+}
@@ -0,0 +1,81 @@
+package sastopo
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Target is a resolved enclosure bay and the device found in it. Enclosure
+// is nil for devices that are not in an enclosure, Device is nil for bays
+// without a device.
+type Target struct {
+	Enclosure *Enclosure
+	Slot      int
+	Device    *MultiPathDevice
+}
+
+// Element returns the SES device slot element of the target's bay, or nil
+// if the target is not in an enclosure or SES did not report the bay
+func (t *Target) Element() *SesElement {
+	if t.Enclosure == nil {
+		return nil
+	}
+	return t.Enclosure.SlotElement(t.Slot)
+}
+
+func (t *Target) String() string {
+	var s []string
+	if t.Enclosure != nil {
+		s = append(s, "Enclosure: "+t.Enclosure.Name(), fmt.Sprintf("Slot: %d", t.Slot))
+		if el := t.Element(); el != nil && el.Descriptor != "" {
+			s = append(s, "Label: "+el.Descriptor)
+		}
+	}
+	if t.Device != nil {
+		s = append(s, "Serial: "+t.Device.Serial())
+	}
+	return strings.Join(s, ", ")
+}
+
+// ResolveTarget finds the device or bay a user supplied target refers to.
+// A target is either a device serial, SAS address, SCSI ID (H:C:T:L), block
+// or SCSI generic device name (sdX, /dev/sdX, sgN), or an enclosure and
+// slot as "<enclosure>:<slot>" where the enclosure is its serial, label or
+// logical ID.
+func ResolveTarget(target string, multiPathDevices map[string]*MultiPathDevice, enclosures map[*Enclosure]bool) (*Target, error) {
+	name := filepath.Base(target)
+	for _, mpd := range multiPathDevices {
+		for device := range mpd.Paths {
+			if device.Serial == target || device.SasAddress == target || device.ID == target ||
+				(device.Block != "" && device.Block == name) || (device.SG != "" && device.SG == name) {
+				return mpd.Target(), nil
+			}
+		}
+	}
+
+	if i := strings.LastIndex(target, ":"); i > 0 {
+		slot, err := strconv.Atoi(target[i+1:])
+		if err == nil {
+			for enclosure := range enclosures {
+				if enclosure.Matches(target[:i]) {
+					return &Target{Enclosure: enclosure, Slot: slot, Device: enclosure.Slots[slot]}, nil
+				}
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%s: %s", ErrTargetNotFound, target)
+}
+
+// Target returns the enclosure bay of the multipath device, with a nil
+// Enclosure if the device is not in an enclosure slot
+func (mpd *MultiPathDevice) Target() *Target {
+	for device := range mpd.Paths {
+		if device.Enclosure != nil && device.Type == 0 && device.SlotSource != "" {
+			return &Target{Enclosure: device.Enclosure, Slot: device.Slot, Device: mpd}
+		}
+	}
+	return &Target{Slot: -1, Device: mpd}
+}
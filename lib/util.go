@@ -39,6 +39,12 @@ func trimPoints(line []byte) (start int, stop int) {
 	return start, stop
 }
 
+// trimString returns b as a string without null and space padding
+func trimString(b []byte) string {
+	start, stop := trimPoints(b)
+	return string(b[start:stop])
+}
+
 // sgSesToBytes takes the []byte output from running "sg_ses --hex"
 // drops all whitespace, and attempts to decode the hex charecters
 // into to their actual values.
@@ -0,0 +1,288 @@
+package sastopo
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// vdev_id.conf topology modes
+const (
+	VdevIDMultipath = "multipath"  // One channel name per enclosure across all its paths
+	VdevIDSasDirect = "sas_direct" // One channel name per HBA port
+)
+
+// defaultPhysPerPort is the vdev_id default number of phys in an HBA port
+const defaultPhysPerPort = 4
+
+// VdevChannel is a vdev_id.conf channel line, mapping an HBA port to a name
+type VdevChannel struct {
+	PciSlot   string // HBA PCI address without domain, ex: 85:00.0
+	Port      int    // HBA port number, lowest phy over phys per port
+	Name      string
+	Enclosure *Enclosure
+}
+
+// channelKey identifies the HBA port an enclosure path goes through
+type channelKey struct {
+	hba  *HBA
+	port string
+}
+
+// portNumber returns the vdev_id port number of an HBA port and the phys per
+// port of the HBA, taken from its widest port
+func portNumber(hba *HBA, portID string) (int, int) {
+	perPort := 0
+	for port := range hba.Ports {
+		if len(port.Phys) > perPort {
+			perPort = len(port.Phys)
+		}
+	}
+	if perPort == 0 {
+		perPort = defaultPhysPerPort
+	}
+
+	port := hba.Port(portID)
+	if port == nil {
+		return 0, perPort
+	}
+	lowest := -1
+	for phy := range port.Phys {
+		if n, err := strconv.Atoi(phy.PhyIdentifier); err == nil && (lowest < 0 || n < lowest) {
+			lowest = n
+		}
+	}
+	if lowest < 0 {
+		return 0, perPort
+	}
+	return lowest / perPort, perPort
+}
+
+// channelName returns the name of the n'th channel: A-Z, then AA, AB, ...
+func channelName(n int) string {
+	name := string(rune('A' + n%26))
+	for n /= 26; n > 0; n /= 26 {
+		name = string(rune('A'+(n-1)%26)) + name
+		n--
+	}
+	return name
+}
+
+// VdevIDChannels returns the channels of every HBA port an enclosure is
+// connected to, sorted by PCI slot and port. Names come from labels, keyed by
+// HBA PCI ID then port ID, or in multipath mode the enclosure's label,
+// otherwise they are lettered in order of enclosure serial, or of enclosure
+// serial and HBA port in sas_direct mode.
+func VdevIDChannels(enclosures map[*Enclosure]bool, mode string, labels map[string]map[string]string) ([]VdevChannel, error) {
+	if mode != VdevIDMultipath && mode != VdevIDSasDirect {
+		return nil, fmt.Errorf("unknown vdev_id mode %s, expected %s or %s", mode, VdevIDMultipath, VdevIDSasDirect)
+	}
+
+	var sorted []*Enclosure
+	for enclosure := range enclosures {
+		sorted = append(sorted, enclosure)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Serial() < sorted[j].Serial() })
+
+	var channels []VdevChannel
+	// Lettered names skip any configured label
+	names := map[string]bool{}
+	for _, ports := range labels {
+		for _, label := range ports {
+			names[label] = true
+		}
+	}
+	for _, enclosure := range sorted {
+		if enclosure.Label != "" && mode == VdevIDMultipath {
+			names[enclosure.Label] = true
+		}
+	}
+	next := 0
+	newName := func() string {
+		for names[channelName(next)] {
+			next++
+		}
+		names[channelName(next)] = true
+		return channelName(next)
+	}
+
+	for _, enclosure := range sorted {
+		var keys []channelKey
+		seen := map[channelKey]bool{}
+		for device := range enclosure.MultiPathDevice.Paths {
+			key := channelKey{device.HBA, device.Port}
+			if device.HBA != nil && !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].hba.PciID != keys[j].hba.PciID {
+				return keys[i].hba.PciID < keys[j].hba.PciID
+			}
+			return keys[i].port < keys[j].port
+		})
+
+		// In multipath mode every path of the enclosure shares a name, its
+		// label or the first one configured
+		shared := ""
+		if mode == VdevIDMultipath {
+			shared = enclosure.Label
+		}
+		if mode == VdevIDMultipath && shared == "" {
+			for _, key := range keys {
+				if label := labels[key.hba.PciID][key.port]; label != "" {
+					shared = label
+					break
+				}
+			}
+		}
+
+		for _, key := range keys {
+			name := labels[key.hba.PciID][key.port]
+			if mode == VdevIDMultipath {
+				name = shared
+			}
+			if name == "" {
+				name = newName()
+				if mode == VdevIDMultipath {
+					shared = name
+				}
+			}
+			names[name] = true
+
+			port, _ := portNumber(key.hba, key.port)
+			pciSlot := key.hba.PciID
+			if i := strings.Index(pciSlot, ":"); i >= 0 && strings.Count(pciSlot, ":") == 2 {
+				pciSlot = pciSlot[i+1:]
+			}
+			channels = append(channels, VdevChannel{PciSlot: pciSlot, Port: port, Name: name, Enclosure: enclosure})
+		}
+	}
+
+	sort.SliceStable(channels, func(i, j int) bool {
+		if channels[i].PciSlot != channels[j].PciSlot {
+			return channels[i].PciSlot < channels[j].PciSlot
+		}
+		return channels[i].Port < channels[j].Port
+	})
+
+	// vdev_id only uses the first channel line of an HBA port, so enclosures
+	// cascaded behind the same port can't be told apart
+	for i := 1; i < len(channels); i++ {
+		a, b := channels[i-1], channels[i]
+		if a.PciSlot == b.PciSlot && a.Port == b.Port && a.Name != b.Name {
+			diagnostic("vdev_id channels %s and %s share HBA %s port %d, only %s will be used", a.Name, b.Name, a.PciSlot, a.Port, a.Name)
+		}
+	}
+	return channels, nil
+}
+
+// vdevIDSlotMode returns the vdev_id slot mapping matching where the slots of
+// the devices were found: bay when every slot is a bay_identifier, otherwise
+// ses
+func vdevIDSlotMode(enclosures map[*Enclosure]bool) string {
+	for enclosure := range enclosures {
+		for _, mpd := range enclosure.Slots {
+			for device := range mpd.Paths {
+				if device.SlotSource != "bay_identifier" {
+					return "ses"
+				}
+			}
+		}
+	}
+	return "bay"
+}
+
+// VdevIDConf returns a ZFS vdev_id.conf for the enclosures in the given mode
+func VdevIDConf(enclosures map[*Enclosure]bool, mode string, labels map[string]map[string]string) (string, error) {
+	channels, err := VdevIDChannels(enclosures, mode, labels)
+	if err != nil {
+		return "", err
+	}
+
+	// vdev_id.conf has a single phys_per_port, so every HBA must agree
+	widths := map[string]int{}
+	for enclosure := range enclosures {
+		for device := range enclosure.MultiPathDevice.Paths {
+			if device.HBA != nil {
+				_, widths[device.HBA.String()] = portNumber(device.HBA, device.Port)
+			}
+		}
+	}
+	var hbas []string
+	for hba := range widths {
+		hbas = append(hbas, hba)
+	}
+	sort.Strings(hbas)
+	perPort := defaultPhysPerPort
+	for i, hba := range hbas {
+		if i == 0 {
+			perPort = widths[hba]
+		} else if widths[hba] != perPort {
+			return "", fmt.Errorf("HBA %s has %d phys per port and HBA %s has %d, vdev_id.conf supports only one phys_per_port", hbas[0], perPort, hba, widths[hba])
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Generated by sastopo generate vdev_id\n")
+	if mode == VdevIDMultipath {
+		fmt.Fprintf(&b, "multipath     yes\n")
+	} else {
+		fmt.Fprintf(&b, "multipath     no\n")
+	}
+	fmt.Fprintf(&b, "topology      sas_direct\n")
+	fmt.Fprintf(&b, "phys_per_port %d\n", perPort)
+	fmt.Fprintf(&b, "slot          %s\n\n", vdevIDSlotMode(enclosures))
+	fmt.Fprintf(&b, "#       PCI_SLOT  PORT  NAME\n")
+	for _, c := range channels {
+		fmt.Fprintf(&b, "channel %-9s %-5d %s", c.PciSlot, c.Port, c.Name)
+		if c.Enclosure != nil {
+			fmt.Fprintf(&b, "  # enclosure %s", c.Enclosure.Name())
+		}
+		fmt.Fprintf(&b, "\n")
+	}
+	return b.String(), nil
+}
+
+// VdevIDAliases returns vdev_id.conf alias lines naming each enclosure slot's
+// device after its channel and slot, ex: A12, using persistent device names
+func VdevIDAliases(enclosures map[*Enclosure]bool, labels map[string]map[string]string) (string, error) {
+	channels, err := VdevIDChannels(enclosures, VdevIDMultipath, labels)
+	if err != nil {
+		return "", err
+	}
+	channelNames := map[*Enclosure]string{}
+	for _, c := range channels {
+		channelNames[c.Enclosure] = c.Name
+	}
+
+	var mpds []*MultiPathDevice
+	for enclosure := range enclosures {
+		for _, mpd := range enclosure.Slots {
+			if mpd.Target().Enclosure != nil {
+				mpds = append(mpds, mpd)
+			}
+		}
+	}
+	sort.Slice(mpds, func(i, j int) bool {
+		ti, tj := mpds[i].Target(), mpds[j].Target()
+		if channelNames[ti.Enclosure] != channelNames[tj.Enclosure] {
+			return channelNames[ti.Enclosure] < channelNames[tj.Enclosure]
+		}
+		return ti.Slot < tj.Slot
+	})
+
+	var lines []string
+	names := PersistentNames(mpds)
+	for _, mpd := range mpds {
+		if names[mpd] == "" {
+			continue
+		}
+		t := mpd.Target()
+		lines = append(lines, fmt.Sprintf("alias %s%d %s", channelNames[t.Enclosure], t.Slot, names[mpd]))
+	}
+	return "# Generated by sastopo generate vdev_id --aliases\n" + strings.Join(lines, "\n") + "\n", nil
+}
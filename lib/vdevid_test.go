@@ -0,0 +1,89 @@
+package sastopo
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestChannelName(t *testing.T) {
+	for n, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 702: "AAA"} {
+		if got := channelName(n); got != want {
+			t.Errorf("channelName(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestVdevIDChannels(t *testing.T) {
+	hbaPort := func(id string, phys ...string) *HBAPort {
+		port := &HBAPort{PortID: id, Phys: map[*Phy]bool{}}
+		for _, phy := range phys {
+			port.Phys[&Phy{PhyIdentifier: phy}] = true
+		}
+		return port
+	}
+	hba0 := &HBA{PciID: "0000:85:00.0", Ports: map[*HBAPort]bool{
+		hbaPort("port-1:0", "0", "1", "2", "3"): true,
+		hbaPort("port-1:1", "4", "5", "6", "7"): true,
+	}}
+	hba1 := &HBA{PciID: "0000:86:00.0", Ports: map[*HBAPort]bool{
+		hbaPort("port-2:0", "4", "5", "6", "7"): true,
+	}}
+	enclosure := func(serial string, paths ...*Device) *Enclosure {
+		e := &Enclosure{MultiPathDevice: &MultiPathDevice{Paths: map[*Device]bool{}}}
+		for _, d := range paths {
+			d.Serial = serial
+			e.MultiPathDevice.Paths[d] = true
+		}
+		return e
+	}
+	enclosures := map[*Enclosure]bool{
+		enclosure("E1", &Device{HBA: hba0, Port: "port-1:0"}, &Device{HBA: hba1, Port: "port-2:0"}): true,
+		enclosure("E2", &Device{HBA: hba0, Port: "port-1:1"}):                                       true,
+	}
+
+	tests := []struct {
+		mode   string
+		labels map[string]map[string]string
+		want   []string
+	}{
+		{VdevIDMultipath, nil, []string{"85:00.0 0 A", "85:00.0 1 B", "86:00.0 1 A"}},
+		{VdevIDSasDirect, nil, []string{"85:00.0 0 A", "85:00.0 1 C", "86:00.0 1 B"}},
+		{VdevIDMultipath, map[string]map[string]string{"0000:86:00.0": {"port-2:0": "JBOD1"}}, []string{"85:00.0 0 JBOD1", "85:00.0 1 A", "86:00.0 1 JBOD1"}},
+	}
+	for _, tt := range tests {
+		channels, err := VdevIDChannels(enclosures, tt.mode, tt.labels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(channels) != len(tt.want) {
+			t.Fatalf("%s: got %d channels, want %d", tt.mode, len(channels), len(tt.want))
+		}
+		for i, c := range channels {
+			if got := fmt.Sprintf("%s %d %s", c.PciSlot, c.Port, c.Name); got != tt.want[i] {
+				t.Errorf("%s: channel %d = %s, want %s", tt.mode, i, got, tt.want[i])
+			}
+		}
+	}
+
+	if _, err := VdevIDChannels(enclosures, "sas_switch", nil); err == nil {
+		t.Errorf("VdevIDChannels(sas_switch) returned no error")
+	}
+
+	conf, err := VdevIDConf(enclosures, VdevIDMultipath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(conf, "phys_per_port 4\n") {
+		t.Errorf("expected phys_per_port 4 in:\n%s", conf)
+	}
+
+	// An HBA with 8 phy wide ports can't share a vdev_id.conf with 4 phy ports
+	hba2 := &HBA{PciID: "0000:87:00.0", Ports: map[*HBAPort]bool{
+		hbaPort("port-3:0", "0", "1", "2", "3", "4", "5", "6", "7"): true,
+	}}
+	enclosures[enclosure("E3", &Device{HBA: hba2, Port: "port-3:0"})] = true
+	if _, err := VdevIDConf(enclosures, VdevIDMultipath, nil); err == nil {
+		t.Errorf("VdevIDConf with different phys per port returned no error")
+	}
+}
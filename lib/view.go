@@ -0,0 +1,363 @@
+package sastopo
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// View is a stable, exported view of the topology for output templates. It
+// holds plain values only, so templates don't depend on how discovery is
+// implemented. Fields may be added but are not renamed or removed.
+type View struct {
+	Host        string
+	HBAs        []ViewHBA
+	Enclosures  []ViewEnclosure
+	Devices     []*ViewDevice // Every drive, in or out of an enclosure
+	Diagnostics []string
+}
+
+// ViewHBA is an HBA in a View
+type ViewHBA struct {
+	PciID      string
+	Slot       string // Slot label
+	Host       string // SCSI host, ex: host1
+	Board      string
+	Firmware   string
+	Bios       string
+	Driver     string
+	SasAddress string
+	LinkSpeed  string // PCIe link speed, ex: 8.0 GT/s PCIe
+	LinkWidth  int
+	NumaNode   int
+	Ports      []ViewPort
+}
+
+// ViewPort is an HBA port in a View
+type ViewPort struct {
+	ID     string // ex: port-1:0
+	Number int    // Port number, lowest phy over phys per port
+	Phys   []ViewPhy
+}
+
+// ViewPhy is an HBA phy in a View
+type ViewPhy struct {
+	ID         string
+	SasAddress string
+	LinkRate   string
+	Errors     int // Sum of the phy's error counters
+}
+
+// ViewEnclosure is an enclosure in a View
+type ViewEnclosure struct {
+	Name           string // Label, or serial when it has none
+	Serial         string
+	Label          string
+	LogicalID      string
+	Vendor         string
+	Model          string
+	Rack           string
+	U              int
+	Chain          int
+	TotalSlots     int
+	PopulatedSlots int
+	Paths          []ViewPath // Paths to the enclosure's SES device
+	Slots          []ViewSlot
+}
+
+// ViewSlot is an enclosure bay in a View
+type ViewSlot struct {
+	Enclosure string // Enclosure name
+	Slot      int
+	Label     string // Slot element descriptor
+	Drawer    string
+	Status    string      // SES element status
+	State     string      // Bay state: empty, ok, faulted, degraded or locate
+	Device    *ViewDevice // nil for an empty bay
+}
+
+// ViewDevice is a drive and its paths in a View
+type ViewDevice struct {
+	Serial     string
+	Vendor     string
+	Model      string
+	Rev        string
+	Rotational bool
+	Size       int64 // Bytes
+	WWID       string
+	Enclosure  string // Enclosure name, empty if not in an enclosure
+	Slot       int    // -1 if not in an enclosure
+	SlotLabel  string
+	Drawer     string
+	Multipath  string // Device-mapper multipath map name, ex: mpatha
+	DMDevice   string // Device-mapper device, ex: dm-0
+	State      string // Bay state, or degraded or ok by path count when not in an enclosure
+	Paths      []ViewPath
+}
+
+// ViewPath is a path to a drive or enclosure in a View
+type ViewPath struct {
+	ID         string // SCSI ID, H:C:T:L
+	Block      string
+	SG         string
+	HBA        string // HBA slot label, or PCI ID if it has none
+	HBAPciID   string
+	Port       string
+	SasAddress string
+	State      string
+	DMState    string
+	IOM        string
+	Expander   string
+}
+
+// viewPaths converts and sorts the paths of a multipath device
+func viewPaths(mpd *MultiPathDevice) []ViewPath {
+	var paths []ViewPath
+	for device := range mpd.Paths {
+		p := ViewPath{
+			ID:         device.ID,
+			Block:      device.Block,
+			SG:         device.SG,
+			Port:       device.Port,
+			SasAddress: device.SasAddress,
+			State:      device.State,
+			DMState:    device.DMState,
+			IOM:        device.IOM,
+			Expander:   device.Expander,
+		}
+		if device.HBA != nil {
+			p.HBA, p.HBAPciID = device.HBA.Name(), device.HBA.PciID
+		}
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i].ID < paths[j].ID })
+	return paths
+}
+
+// NewView builds the View of a discovered topology. HBAs are sorted by PCI
+// ID, enclosures by name, slots by number and devices by enclosure, slot
+// and serial. Bays with fewer than pathCount paths are degraded.
+func NewView(multiPathDevices map[string]*MultiPathDevice, enclosures map[*Enclosure]bool, HBAs map[string]*HBA, pathCount int) *View {
+	v := &View{Diagnostics: Diagnostics()}
+	v.Host, _ = os.Hostname()
+
+	for _, hba := range HBAs {
+		h := ViewHBA{
+			PciID:      hba.PciID,
+			Slot:       hba.Slot,
+			Host:       hba.Host,
+			Board:      hba.Inventory.BoardName,
+			Firmware:   hba.Inventory.FirmwareVersion,
+			Bios:       hba.Inventory.BiosVersion,
+			Driver:     hba.Inventory.Driver,
+			SasAddress: hba.Inventory.SasAddress,
+			LinkSpeed:  hba.Link.CurrentSpeed,
+			LinkWidth:  hba.Link.CurrentWidth,
+			NumaNode:   hba.Link.NumaNode,
+		}
+		for port := range hba.Ports {
+			p := ViewPort{ID: port.PortID}
+			p.Number, _ = portNumber(hba, port.PortID)
+			for _, id := range sortedPhyIds(port.Phys) {
+				for phy := range port.Phys {
+					if phy.PhyIdentifier == id {
+						p.Phys = append(p.Phys, ViewPhy{ID: id, SasAddress: phy.SasAddress, LinkRate: phy.LinkRate, Errors: phy.Errors.Total()})
+					}
+				}
+			}
+			h.Ports = append(h.Ports, p)
+		}
+		sort.Slice(h.Ports, func(i, j int) bool { return h.Ports[i].Number < h.Ports[j].Number })
+		v.HBAs = append(v.HBAs, h)
+	}
+	sort.Slice(v.HBAs, func(i, j int) bool { return v.HBAs[i].PciID < v.HBAs[j].PciID })
+
+	devices := map[*MultiPathDevice]*ViewDevice{}
+	seen := map[*MultiPathDevice]bool{}
+	for _, mpd := range multiPathDevices {
+		if seen[mpd] {
+			continue
+		}
+		seen[mpd] = true
+		disk := false
+		for device := range mpd.Paths {
+			disk = disk || device.Type == 0
+		}
+		if !disk {
+			continue
+		}
+		d := &ViewDevice{
+			Serial:    mpd.Serial(),
+			Vendor:    mpd.Vendor(),
+			Model:     mpd.Model(),
+			Rev:       mpd.Rev(),
+			WWID:      mpd.WWID(),
+			Slot:      -1,
+			SlotLabel: mpd.SlotLabel(),
+			Paths:     viewPaths(mpd),
+		}
+		for device := range mpd.Paths {
+			d.Rotational = d.Rotational || device.Rotational
+			if device.Size > d.Size {
+				d.Size = device.Size
+			}
+			if device.Drawer != "" {
+				d.Drawer = device.Drawer
+			}
+		}
+		if t := mpd.Target(); t.Enclosure != nil {
+			d.Enclosure, d.Slot = t.Enclosure.Name(), t.Slot
+			d.State = t.Enclosure.BayState(t.Slot, pathCount)
+		} else if len(mpd.Paths) < pathCount {
+			d.State = BayDegraded
+		} else {
+			d.State = BayOK
+		}
+		if mpd.DM != nil {
+			d.Multipath, d.DMDevice = mpd.DM.Map, mpd.DM.Name
+		}
+		devices[mpd] = d
+		v.Devices = append(v.Devices, d)
+	}
+	sort.Slice(v.Devices, func(i, j int) bool {
+		a, b := v.Devices[i], v.Devices[j]
+		if a.Enclosure != b.Enclosure {
+			return a.Enclosure < b.Enclosure
+		}
+		if a.Slot != b.Slot {
+			return a.Slot < b.Slot
+		}
+		return a.Serial < b.Serial
+	})
+
+	for enclosure := range enclosures {
+		e := ViewEnclosure{
+			Name:           enclosure.Name(),
+			Serial:         enclosure.Serial(),
+			Label:          enclosure.Label,
+			LogicalID:      enclosure.LogicalID(),
+			Vendor:         enclosure.Vendor(),
+			Model:          enclosure.Model(),
+			Rack:           enclosure.Rack,
+			U:              enclosure.U,
+			Chain:          enclosure.Chain,
+			TotalSlots:     enclosure.TotalSlots(),
+			PopulatedSlots: enclosure.PopulatedSlots(),
+			Paths:          viewPaths(enclosure.MultiPathDevice),
+		}
+		for _, grid := range enclosure.BayGrids(nil, pathCount) {
+			for _, bay := range grid.Bays {
+				s := ViewSlot{Enclosure: e.Name, Slot: bay.Slot, Label: bay.Label, Drawer: grid.Name, State: bay.State}
+				if el := enclosure.SlotElement(bay.Slot); el != nil {
+					s.Status = el.StatusString()
+				}
+				if bay.Device != nil {
+					s.Device = devices[bay.Device]
+				}
+				e.Slots = append(e.Slots, s)
+			}
+		}
+		sort.Slice(e.Slots, func(i, j int) bool { return e.Slots[i].Slot < e.Slots[j].Slot })
+		v.Enclosures = append(v.Enclosures, e)
+	}
+	sort.Slice(v.Enclosures, func(i, j int) bool { return v.Enclosures[i].Name < v.Enclosures[j].Name })
+	return v
+}
+
+// sortBy returns a copy of a slice of structs, or of pointers to structs,
+// sorted by the named field
+func sortBy(field string, list interface{}) (interface{}, error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("sortBy: %s is not a list", v.Type())
+	}
+	sorted := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+	reflect.Copy(sorted, v)
+
+	key := func(i int) (reflect.Value, error) {
+		e := reflect.Indirect(sorted.Index(i))
+		if e.Kind() != reflect.Struct {
+			return reflect.Value{}, fmt.Errorf("sortBy: %s has no fields", e.Type())
+		}
+		f := e.FieldByName(field)
+		if !f.IsValid() {
+			return reflect.Value{}, fmt.Errorf("sortBy: %s has no field %s", e.Type(), field)
+		}
+		return f, nil
+	}
+	var err error
+	sort.SliceStable(sorted.Interface(), func(i, j int) bool {
+		a, errA := key(i)
+		b, errB := key(j)
+		if errA != nil || errB != nil {
+			if err == nil {
+				err = errA
+				if err == nil {
+					err = errB
+				}
+			}
+			return false
+		}
+		switch a.Kind() {
+		case reflect.String:
+			return a.String() < b.String()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return a.Int() < b.Int()
+		case reflect.Bool:
+			return !a.Bool() && b.Bool()
+		}
+		return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+	})
+	return sorted.Interface(), err
+}
+
+// csvRow formats its arguments as one CSV record, without the line ending
+func csvRow(fields ...interface{}) (string, error) {
+	record := make([]string, len(fields))
+	for i, f := range fields {
+		record[i] = fmt.Sprint(f)
+	}
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	if err := w.Write(record); err != nil {
+		return "", err
+	}
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n"), w.Error()
+}
+
+// ViewFuncs are the helper functions available to View templates:
+//
+//	sortBy "Field" list   sort a list of structs by a field
+//	join list sep         join a list of strings
+//	csv a b ...           format values as a CSV record
+//	json value            format a value as JSON
+//	pad width value       left align a value in a column of width
+//	upper, lower          change the case of a string
+var ViewFuncs = template.FuncMap{
+	"sortBy": sortBy,
+	"join": func(list []string, sep string) string {
+		return strings.Join(list, sep)
+	},
+	"csv": csvRow,
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"pad": func(width int, v interface{}) string {
+		return fmt.Sprintf("%-*v", width, v)
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// ParseViewTemplate parses a text/template to execute over a View, with
+// ViewFuncs available
+func ParseViewTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(ViewFuncs).Parse(text)
+}
@@ -0,0 +1,48 @@
+package sastopo
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNewView(t *testing.T) {
+	hba := &HBA{PciID: "0000:85:00.0", Slot: "C5", Host: "host1"}
+	encl := &Enclosure{
+		MultiPathDevice: &MultiPathDevice{Paths: map[*Device]bool{{ID: "1:0:0:0", Type: 13, Serial: "SN-ENCL", HBA: hba}: true}},
+		Slots:           map[int]*MultiPathDevice{},
+		Label:           "e01",
+	}
+	multiPathDevices := map[string]*MultiPathDevice{}
+	for i, serial := range []string{"SN-B", "SN-A"} {
+		mpd := &MultiPathDevice{Paths: map[*Device]bool{}}
+		mpd.Paths[&Device{ID: "1:0:" + string(rune('1'+i)) + ":0", Type: 0, Serial: serial, Block: "sd" + string(rune('b'+i)),
+			HBA: hba, Enclosure: encl, Slot: i, SlotSource: "ses", MultiPath: mpd}] = true
+		encl.Slots[i] = mpd
+		multiPathDevices[serial] = mpd
+	}
+
+	v := NewView(multiPathDevices, map[*Enclosure]bool{encl: true}, map[string]*HBA{hba.PciID: hba}, 1)
+	if len(v.HBAs) != 1 || len(v.Enclosures) != 1 || len(v.Devices) != 2 {
+		t.Fatalf("unexpected view: %#v", v)
+	}
+	if e := v.Enclosures[0]; e.Name != "e01" || len(e.Slots) != 2 || e.Slots[1].Device == nil || e.Slots[1].Device.Serial != "SN-A" {
+		t.Errorf("unexpected enclosure: %#v", e)
+	}
+
+	tmpl, err := ParseViewTemplate("test", `{{range sortBy "Serial" .Devices}}{{csv .Serial .Enclosure .Slot (index .Paths 0).HBA}}
+{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, v); err != nil {
+		t.Fatal(err)
+	}
+	if want := "SN-A,e01,1,C5\nSN-B,e01,0,C5\n"; b.String() != want {
+		t.Errorf("template output %q, want %q", b.String(), want)
+	}
+
+	if _, err := sortBy("Missing", v.Devices); err == nil {
+		t.Error("expected an error sorting by a missing field")
+	}
+}
@@ -0,0 +1,261 @@
+package sastopo
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Failure domains a vdev's members can share
+const (
+	DomainEnclosure = "enclosure"
+	DomainDrawer    = "drawer"
+	DomainHBA       = "hba"
+	DomainIOM       = "iom"
+)
+
+// Domains are the failure domains a ZfsPlan is checked against
+var Domains = []string{DomainEnclosure, DomainDrawer, DomainHBA, DomainIOM}
+
+// Vdev is a planned ZFS vdev
+type Vdev struct {
+	Type    string // raidz1, raidz2, raidz3, mirror or a dRAID spec, ex: draid2:8d:10c:0s
+	Parity  int    // Members that can be lost without losing the vdev
+	Members []*MultiPathDevice
+}
+
+// ZfsPlan is a ZFS pool layout of vdevs and spares
+type ZfsPlan struct {
+	Vdevs  []*Vdev
+	Spares []*MultiPathDevice
+}
+
+// vdevSpec returns the vdev type as given to zpool create and the number of
+// members a vdev of width members can lose. dRAID types take zpool's
+// optional data and distributed spare counts, ex: draid2:8d:2s, and are
+// returned in full with the width as the children, ex: draid2:8d:10c:2s.
+func vdevSpec(vdevType string, width int) (string, int, error) {
+	switch {
+	case vdevType == "mirror":
+		return vdevType, width - 1, nil
+	case vdevType == "raidz":
+		return vdevType, 1, nil
+	case strings.HasPrefix(vdevType, "raidz"):
+		p, err := strconv.Atoi(vdevType[5:])
+		if err == nil && p >= 1 && p <= 3 {
+			return vdevType, p, nil
+		}
+	case strings.HasPrefix(vdevType, "draid"):
+		return draidSpec(vdevType, width)
+	}
+	return "", 0, fmt.Errorf("unknown vdev type %s, expected mirror, raidz1-3 or draid1-3", vdevType)
+}
+
+// draidSpec returns the full dRAID vdev type, draid<parity>:<data>d:<children>c:<spares>s,
+// of a vdev of width children
+func draidSpec(vdevType string, width int) (string, int, error) {
+	fields := strings.Split(vdevType, ":")
+	parity := 1
+	if fields[0] != "draid" {
+		p, err := strconv.Atoi(fields[0][5:])
+		if err != nil || p < 1 || p > 3 {
+			return "", 0, fmt.Errorf("unknown vdev type %s, expected draid1-3", vdevType)
+		}
+		parity = p
+	}
+
+	data, spares := 0, 0
+	for _, field := range fields[1:] {
+		if len(field) < 2 {
+			return "", 0, fmt.Errorf("invalid dRAID option %s in %s, expected <data>d or <spares>s", field, vdevType)
+		}
+		n, err := strconv.Atoi(field[:len(field)-1])
+		if err != nil || n < 0 {
+			return "", 0, fmt.Errorf("invalid dRAID option %s in %s, expected <data>d or <spares>s", field, vdevType)
+		}
+		switch field[len(field)-1] {
+		case 'd':
+			data = n
+		case 's':
+			spares = n
+		case 'c':
+			if n != width {
+				return "", 0, fmt.Errorf("%s has %d children, the vdev width is %d", vdevType, n, width)
+			}
+		default:
+			return "", 0, fmt.Errorf("invalid dRAID option %s in %s, expected <data>d or <spares>s", field, vdevType)
+		}
+	}
+	if data == 0 {
+		// zpool's default of 8 data members, fewer for narrow vdevs
+		data = width - parity - spares
+		if data > 8 {
+			data = 8
+		}
+	}
+	if data < 1 || data+parity > width-spares {
+		return "", 0, fmt.Errorf("a %s vdev of %d children has room for %d data members", vdevType, width, width-parity-spares)
+	}
+	return fmt.Sprintf("draid%d:%dd:%dc:%ds", parity, data, width, spares), parity, nil
+}
+
+// domainKeys returns the values of a failure domain whose loss takes out
+// the device. Losing an HBA or IOM only takes out a device if every path
+// uses it.
+func domainKeys(mpd *MultiPathDevice, domain string) []string {
+	switch domain {
+	case DomainEnclosure:
+		if t := mpd.Target(); t.Enclosure != nil {
+			return []string{t.Enclosure.Name()}
+		}
+	case DomainDrawer:
+		if t := mpd.Target(); t.Enclosure != nil {
+			if d := t.Enclosure.Drawer(t.Slot); d != nil {
+				return []string{t.Enclosure.Name() + " " + d.Name}
+			}
+		}
+	case DomainHBA:
+		hbas := map[string]bool{}
+		for device := range mpd.Paths {
+			if device.HBA != nil {
+				hbas[device.HBA.PciID] = true
+			}
+		}
+		if len(hbas) == 1 {
+			for hba := range hbas {
+				return []string{hba}
+			}
+		}
+	case DomainIOM:
+		// IOM names are only unique within an enclosure
+		ioms := map[string]bool{}
+		for device := range mpd.Paths {
+			if device.IOM == "" {
+				return nil
+			}
+			if device.Enclosure != nil {
+				ioms[device.Enclosure.Name()+" "+device.IOM] = true
+			} else {
+				ioms[device.IOM] = true
+			}
+		}
+		if len(ioms) == 1 {
+			for iom := range ioms {
+				return []string{iom}
+			}
+		}
+	}
+	return nil
+}
+
+// domainKey returns an interleave key of the values of a failure domain
+func domainKey(domain string) func(*MultiPathDevice) string {
+	return func(mpd *MultiPathDevice) string {
+		return strings.Join(domainKeys(mpd, domain), ",")
+	}
+}
+
+// DomainCounts returns how many members are lost with each value of the
+// failure domain
+func (v *Vdev) DomainCounts(domain string) map[string]int {
+	counts := map[string]int{}
+	for _, mpd := range v.Members {
+		for _, key := range domainKeys(mpd, domain) {
+			counts[key]++
+		}
+	}
+	return counts
+}
+
+// Tolerates returns true if the vdev survives losing any single value of
+// the failure domain
+func (v *Vdev) Tolerates(domain string) bool {
+	for _, n := range v.DomainCounts(domain) {
+		if n > v.Parity {
+			return false
+		}
+	}
+	return true
+}
+
+// interleave orders devices so that consecutive devices differ in the first
+// key as much as possible, then in the following keys within each group.
+// Groups are drawn from round robin, largest first.
+func interleave(mpds []*MultiPathDevice, keys ...func(*MultiPathDevice) string) []*MultiPathDevice {
+	if len(keys) == 0 || len(mpds) < 2 {
+		return mpds
+	}
+
+	groups := map[string][]*MultiPathDevice{}
+	var names []string
+	for _, mpd := range mpds {
+		k := keys[0](mpd)
+		if groups[k] == nil {
+			names = append(names, k)
+		}
+		groups[k] = append(groups[k], mpd)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(groups[names[i]]) != len(groups[names[j]]) {
+			return len(groups[names[i]]) > len(groups[names[j]])
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		groups[name] = interleave(groups[name], keys[1:]...)
+	}
+
+	var ordered []*MultiPathDevice
+	for i := 0; len(ordered) < len(mpds); i++ {
+		for _, name := range names {
+			if i < len(groups[name]) {
+				ordered = append(ordered, groups[name][i])
+			}
+		}
+	}
+	return ordered
+}
+
+// PlanZfs lays out the devices into vdevs of width members, spreading each
+// vdev's members across enclosures, then across the drawers of each
+// enclosure, then across the HBAs of single path devices, as evenly as
+// possible. Devices left over become spares.
+func PlanZfs(mpds []*MultiPathDevice, vdevType string, width int) (*ZfsPlan, error) {
+	if width < 1 {
+		return nil, fmt.Errorf("vdev width must be at least 1")
+	}
+	spec, parity, err := vdevSpec(vdevType, width)
+	if err != nil {
+		return nil, err
+	}
+	if width <= parity || (vdevType == "mirror" && width < 2) {
+		return nil, fmt.Errorf("a %s vdev needs more than %d members", vdevType, parity)
+	}
+	if len(mpds) < width {
+		return nil, fmt.Errorf("found %d devices, need at least %d for one %s vdev", len(mpds), width, vdevType)
+	}
+
+	// Start from physical order so the plan is stable between runs
+	sorted := append([]*MultiPathDevice(nil), mpds...)
+	sort.Slice(sorted, func(i, j int) bool {
+		ti, tj := sorted[i].Target(), sorted[j].Target()
+		if ti.Slot != tj.Slot {
+			return ti.Slot < tj.Slot
+		}
+		return sorted[i].Serial() < sorted[j].Serial()
+	})
+	ordered := interleave(sorted, domainKey(DomainEnclosure), domainKey(DomainDrawer), domainKey(DomainHBA))
+
+	plan := &ZfsPlan{}
+	n := len(ordered) / width
+	for i := 0; i < n; i++ {
+		plan.Vdevs = append(plan.Vdevs, &Vdev{
+			Type:    spec,
+			Parity:  parity,
+			Members: ordered[i*width : (i+1)*width],
+		})
+	}
+	plan.Spares = ordered[n*width:]
+	return plan, nil
+}
@@ -0,0 +1,184 @@
+package sastopo
+
+import (
+	"fmt"
+	"testing"
+)
+
+// testZfsDevices returns slots drives in each of the enclosures, every drive
+// with one path through each HBA
+func testZfsDevices(enclosures, slots int, hbas ...*HBA) []*MultiPathDevice {
+	var mpds []*MultiPathDevice
+	for e := 0; e < enclosures; e++ {
+		encl := &Enclosure{MultiPathDevice: &MultiPathDevice{Paths: map[*Device]bool{
+			{Serial: fmt.Sprintf("ENCL%d", e)}: true,
+		}}}
+		for s := 0; s < slots; s++ {
+			mpd := &MultiPathDevice{Paths: map[*Device]bool{}}
+			for _, hba := range hbas {
+				d := &Device{Type: 0, Serial: fmt.Sprintf("SN%d-%d", e, s), Enclosure: encl, Slot: s, SlotSource: "ses", HBA: hba, MultiPath: mpd}
+				mpd.Paths[d] = true
+			}
+			mpds = append(mpds, mpd)
+		}
+	}
+	return mpds
+}
+
+// testSplitHBAs moves the single path of the second half of the devices to hba
+func testSplitHBAs(mpds []*MultiPathDevice, hba *HBA) []*MultiPathDevice {
+	for _, mpd := range mpds[len(mpds)/2:] {
+		for device := range mpd.Paths {
+			device.HBA = hba
+		}
+	}
+	return mpds
+}
+
+func TestPlanZfs(t *testing.T) {
+	hba0, hba1 := &HBA{PciID: "0000:01:00.0"}, &HBA{PciID: "0000:02:00.0"}
+
+	tests := []struct {
+		name       string
+		mpds       []*MultiPathDevice
+		vdevType   string
+		width      int
+		vdevs      int
+		spares     int
+		enclosure  bool
+		hba        bool
+		maxPerEncl int
+	}{
+		{"five enclosures raidz2", testZfsDevices(5, 12, hba0, hba1), "raidz2", 10, 6, 0, true, true, 2},
+		{"three enclosures raidz2", testZfsDevices(3, 12, hba0, hba1), "raidz2", 10, 3, 6, false, true, 4},
+		{"single path mirror", testZfsDevices(2, 3, hba0), "mirror", 2, 3, 0, true, false, 1},
+		{"half the slots per HBA mirror", testSplitHBAs(testZfsDevices(1, 8, hba0), hba1), "mirror", 2, 4, 0, false, true, 2},
+	}
+	for _, tt := range tests {
+		plan, err := PlanZfs(tt.mpds, tt.vdevType, tt.width)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if len(plan.Vdevs) != tt.vdevs || len(plan.Spares) != tt.spares {
+			t.Errorf("%s: got %d vdevs and %d spares, want %d and %d", tt.name, len(plan.Vdevs), len(plan.Spares), tt.vdevs, tt.spares)
+		}
+		for i, vdev := range plan.Vdevs {
+			if got := vdev.Tolerates(DomainEnclosure); got != tt.enclosure {
+				t.Errorf("%s: vdev %d tolerates enclosure loss %t, want %t", tt.name, i, got, tt.enclosure)
+			}
+			if got := vdev.Tolerates(DomainHBA); got != tt.hba {
+				t.Errorf("%s: vdev %d tolerates HBA loss %t, want %t", tt.name, i, got, tt.hba)
+			}
+			for serial, n := range vdev.DomainCounts(DomainEnclosure) {
+				if n > tt.maxPerEncl {
+					t.Errorf("%s: vdev %d has %d members in %s, want at most %d", tt.name, i, n, serial, tt.maxPerEncl)
+				}
+			}
+		}
+	}
+}
+
+func TestVdevSpec(t *testing.T) {
+	for _, tt := range []struct {
+		vdevType string
+		width    int
+		spec     string
+		parity   int
+	}{
+		{"raidz2", 10, "raidz2", 2},
+		{"mirror", 3, "mirror", 2},
+		{"draid", 5, "draid1:4d:5c:0s", 1},
+		{"draid2", 14, "draid2:8d:14c:0s", 2},
+		{"draid2:4d:2s", 14, "draid2:4d:14c:2s", 2},
+		{"draid3:8d:14c", 14, "draid3:8d:14c:0s", 3},
+	} {
+		spec, parity, err := vdevSpec(tt.vdevType, tt.width)
+		if err != nil {
+			t.Errorf("vdevSpec(%s, %d): %s", tt.vdevType, tt.width, err)
+			continue
+		}
+		if spec != tt.spec || parity != tt.parity {
+			t.Errorf("vdevSpec(%s, %d) = %s, %d, want %s, %d", tt.vdevType, tt.width, spec, parity, tt.spec, tt.parity)
+		}
+	}
+}
+
+func TestPlanZfsDrawers(t *testing.T) {
+	// One enclosure with slots 0-5 in drawer 1 and 6-11 in drawer 2
+	mpds := testZfsDevices(1, 12, &HBA{PciID: "0000:01:00.0"})
+	encl := mpds[0].Target().Enclosure
+	for slot := 0; slot < 12; slot++ {
+		encl.Elements = append(encl.Elements, &SesElement{
+			Type:       SesTypeArrayDeviceSlot,
+			Slot:       slot,
+			Descriptor: fmt.Sprintf("Drawer %d Slot %d", slot/6+1, slot%6),
+		})
+	}
+	encl.updateDrawers()
+
+	plan, err := PlanZfs(mpds, "mirror", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, vdev := range plan.Vdevs {
+		if !vdev.Tolerates(DomainDrawer) {
+			t.Errorf("vdev %d does not tolerate drawer loss: %v", i, vdev.DomainCounts(DomainDrawer))
+		}
+	}
+}
+
+func TestPlanZfsIOMs(t *testing.T) {
+	hba0, hba1 := &HBA{PciID: "0000:01:00.0"}, &HBA{PciID: "0000:02:00.0"}
+	mpds := testZfsDevices(3, 12, hba0, hba1)
+	for _, mpd := range mpds {
+		for device := range mpd.Paths {
+			device.IOM = map[*HBA]string{hba0: "IOM A", hba1: "IOM B"}[device.HBA]
+		}
+	}
+	plan, err := PlanZfs(mpds, "raidz2", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, vdev := range plan.Vdevs {
+		if !vdev.Tolerates(DomainIOM) || len(vdev.DomainCounts(DomainIOM)) != 0 {
+			t.Errorf("vdev %d with paths through both IOMs: %v", i, vdev.DomainCounts(DomainIOM))
+		}
+	}
+
+	// Every path through the same IOM of each enclosure
+	for _, mpd := range mpds {
+		for device := range mpd.Paths {
+			device.IOM = "IOM A"
+		}
+	}
+	for i, vdev := range plan.Vdevs {
+		if vdev.Tolerates(DomainIOM) {
+			t.Errorf("vdev %d tolerates IOM loss with single IOM paths: %v", i, vdev.DomainCounts(DomainIOM))
+		}
+		if n, want := vdev.DomainCounts(DomainIOM)["ENCL0 IOM A"], vdev.DomainCounts(DomainEnclosure)["ENCL0"]; n != want {
+			t.Errorf("vdev %d has %d members behind ENCL0 IOM A, want %d", i, n, want)
+		}
+	}
+}
+
+func TestPlanZfsErrors(t *testing.T) {
+	mpds := testZfsDevices(1, 4, &HBA{PciID: "0000:01:00.0"})
+	for _, tt := range []struct {
+		vdevType string
+		width    int
+	}{
+		{"raidz4", 4},
+		{"raidz2", 2},
+		{"mirror", 1},
+		{"raidz1", 5},
+		{"draid4", 4},
+		{"draid2:3d", 4},
+		{"draid1:2d:2s", 4},
+		{"draid1:3c", 4},
+		{"draid1:x", 4},
+	} {
+		if _, err := PlanZfs(mpds, tt.vdevType, tt.width); err == nil {
+			t.Errorf("PlanZfs(%s, %d) returned no error", tt.vdevType, tt.width)
+		}
+	}
+}